@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// aliasIndices returns the indices an alias points to, each with the
+// filter of the alias on it (nil if it has none), or nil if name is not an
+// alias.
+func aliasIndices(ctx context.Context, client *elastic.Client, name string) (map[string]json.RawMessage, error) {
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_alias/" + url.PathEscape(name),
+	})
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting alias %s: %s", name, err.Error())
+	}
+	var aliases map[string]struct {
+		Aliases map[string]struct {
+			Filter json.RawMessage `json:"filter"`
+		} `json:"aliases"`
+	}
+	if err = json.Unmarshal(res.Body, &aliases); err != nil {
+		return nil, fmt.Errorf("error parsing alias %s: %s", name, err.Error())
+	}
+	indices := make(map[string]json.RawMessage)
+	for index, a := range aliases {
+		if alias, ok := a.Aliases[name]; ok {
+			indices[index] = alias.Filter
+		}
+	}
+	if len(indices) == 0 {
+		return nil, nil
+	}
+	return indices, nil
+}
+
+// aliasFilter returns a query limiting each index to the filter of the
+// alias it was resolved from, or nil if none of them has a filter. The
+// filters of aliases are only applied by searches through the alias, not
+// on the concrete indices.
+func aliasFilter(filters map[string]json.RawMessage) elastic.Query {
+	var plain []interface{}
+	var clauses []elastic.Query
+	indices := make([]string, 0, len(filters))
+	for index := range filters {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	for _, index := range indices {
+		if f := filters[index]; f != nil {
+			clauses = append(clauses, elastic.NewBoolQuery().Filter(elastic.NewTermQuery("_index", index), elastic.NewRawStringQuery(string(f))))
+		} else {
+			plain = append(plain, index)
+		}
+	}
+	if len(clauses) == 0 {
+		return nil
+	}
+	if len(plain) > 0 {
+		clauses = append(clauses, elastic.NewTermsQuery("_index", plain...))
+	}
+	return elastic.NewBoolQuery().Should(clauses...).MinimumNumberShouldMatch(1)
+}
+
+// andQuery returns a query matching all of the queries that are not nil,
+// or nil if they all are.
+func andQuery(queries ...elastic.Query) elastic.Query {
+	var all []elastic.Query
+	for _, q := range queries {
+		if q != nil {
+			all = append(all, q)
+		}
+	}
+	switch len(all) {
+	case 0:
+		return nil
+	case 1:
+		return all[0]
+	}
+	return elastic.NewBoolQuery().Filter(all...)
+}
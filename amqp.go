@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/olivere/elastic/v7"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/errgroup"
+)
+
+// AMQP publishing settings for exports.
+var (
+	amqpExchange   = exportCmd.Flag("amqp-exchange", "AMQP exchange to publish exported documents to (the default exchange if empty)").String()
+	amqpRoutingKey = exportCmd.Flag("amqp-routing-key", "AMQP routing key for each document, with {_index}, {_id} or {field.path} replaced from the document").Default("{_index}").String()
+)
+
+// routingKeyVar matches the {name} placeholders in a routing key.
+var routingKeyVar = regexp.MustCompile(`\{([^}]+)\}`)
+
+// routingKey replaces the placeholders in a routing key pattern with the
+// hit metadata or the values of document fields.
+func routingKey(pattern string, hit elastic.SearchHit) string {
+	return routingKeyVar.ReplaceAllStringFunc(pattern, func(m string) string {
+		switch name := m[1 : len(m)-1]; name {
+		case "_index":
+			return hit.Index
+		case "_id":
+			return hit.Id
+		default:
+			return gjson.GetBytes(hit.Source, name).String()
+		}
+	})
+}
+
+// writeDataToAMQP publishes the _source of each document sent on channel to
+// an AMQP exchange, with the _id as the message id and the _index as a
+// header. Publisher confirms are waited on in batches, so the export fails
+// if the broker rejects any document and is slowed down by a slow broker.
+func writeDataToAMQP(ctx context.Context, g *errgroup.Group, dst string, hits chan interface{}) error {
+	conn, err := amqp.Dial(dst)
+	if err != nil {
+		return fmt.Errorf("error connecting to amqp broker: %s", err.Error())
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error opening amqp channel: %s", err.Error())
+	}
+
+	g.Go(func() error {
+		defer conn.Close()
+		confirms := make([]*amqp.DeferredConfirmation, 0, 1000)
+		wait := func() error {
+			for _, c := range confirms {
+				ok, err := c.WaitContext(ctx)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("amqp broker rejected a document published to exchange %q", *amqpExchange)
+				}
+			}
+			bar.Add64(int64(len(confirms)))
+			confirms = confirms[:0]
+			return nil
+		}
+		for h := range hits {
+			hit, err := toHit(h)
+			if err != nil {
+				return err
+			}
+			c, err := ch.PublishWithDeferredConfirmWithContext(ctx, *amqpExchange, routingKey(*amqpRoutingKey, hit), false, false, amqp.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp.Persistent,
+				MessageId:    hit.Id,
+				Headers:      amqp.Table{"_index": hit.Index},
+				Body:         hit.Source,
+			})
+			if err != nil {
+				return fmt.Errorf("error publishing to amqp exchange %q: %s", *amqpExchange, err.Error())
+			}
+			confirms = append(confirms, c)
+			if len(confirms) == cap(confirms) {
+				if err := wait(); err != nil {
+					return err
+				}
+			}
+		}
+		return wait()
+	})
+	return nil
+}
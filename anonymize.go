@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Anonymization of personal data.
+var (
+	hashFields = app.Flag("hash-field", "Replace the value of a field with a keyed hash that keeps its format (letters, digits and punctuation), a dot path whose segments may be wildcards; arrays and objects there are hashed value by value (repeatable)").Strings()
+	fakeFields = app.Flag("fake-field", "Replace the value of a field with a fake value of a kind, derived from the original (field=kind, repeatable; kinds: person_name, first_name, last_name, email, phone, ipv4)").Strings()
+	hashKey    = app.Flag("hash-key", "Secret key for --hash-field and --fake-field, required so values cannot be recovered by hashing guesses").Envar("VANDELAY_HASH_KEY").String()
+)
+
+func init() {
+	registerTransform("hash", newHashTransform)
+	registerTransform("fake", newFakeTransform)
+}
+
+// digest returns n pseudo-random bytes derived from the key and value, so
+// the same value is always anonymized the same way.
+func digest(value string, n int) []byte {
+	var b []byte
+	for i := uint32(0); len(b) < n; i++ {
+		mac := hmac.New(sha256.New, []byte(*hashKey))
+		binary.Write(mac, binary.BigEndian, i)
+		mac.Write([]byte(value))
+		b = mac.Sum(b)
+	}
+	return b[:n]
+}
+
+// hashValue replaces each letter of s with a letter of the same case and
+// each digit with a digit, keeping any other characters.
+func hashValue(s string) string {
+	r := []rune(s)
+	d := digest(s, len(r))
+	for i, c := range r {
+		switch {
+		case unicode.IsUpper(c):
+			r[i] = 'A' + rune(d[i]%26)
+		case unicode.IsLetter(c):
+			r[i] = 'a' + rune(d[i]%26)
+		case unicode.IsDigit(c):
+			r[i] = '0' + rune(d[i]%10)
+		}
+	}
+	return string(r)
+}
+
+// hashDigits replaces each digit of a JSON number with a digit, keeping the
+// sign, decimal point and exponent, so that it is still a valid number of
+// the same magnitude.
+func hashDigits(s string) []byte {
+	h := []byte(s)
+	d := digest(s, len(h))
+	for i, c := range h {
+		if c == 'e' || c == 'E' {
+			break
+		}
+		if c >= '0' && c <= '9' {
+			h[i] = '0' + d[i]%10
+		}
+	}
+	// Avoid a leading zero, which is not valid JSON.
+	i := 0
+	if len(h) > 0 && h[0] == '-' {
+		i = 1
+	}
+	if i+1 < len(h) && h[i] == '0' && h[i+1] >= '0' && h[i+1] <= '9' {
+		h[i] = '1'
+	}
+	return h
+}
+
+// newHashTransform hashes the strings and numbers at a dot path, see
+// hashValue, including those in an array or object at the path. Numbers
+// stay numbers. A path segment may be a wildcard pattern, as for drop.
+func newHashTransform(arg string) (transformer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("use hash:path")
+	}
+	return newAnonymizeTransform(arg, func(v gjson.Result) []byte {
+		if v.Type == gjson.Number {
+			return hashDigits(v.Raw)
+		}
+		b, _ := json.Marshal(hashValue(v.String()))
+		return b
+	})
+}
+
+// newAnonymizeTransform replaces each string and number at the fields that
+// match a dot path, or in an array or object there, with replace(value).
+func newAnonymizeTransform(arg string, replace func(v gjson.Result) []byte) (transformer, error) {
+	if *hashKey == "" {
+		return nil, fmt.Errorf("--hash-key is required, without it the values can be recovered by hashing guesses")
+	}
+	segments := strings.Split(arg, ".")
+	for _, s := range segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s", arg)
+		}
+	}
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		var err error
+		for _, p := range matchPaths(doc, "", segments) {
+			v := gjson.GetBytes(doc, p)
+			if doc, err = sjson.SetRawBytes(doc, p, replaceLeaves(v, replace)); err != nil {
+				return nil, false, err
+			}
+		}
+		return doc, true, nil
+	}), nil
+}
+
+// replaceLeaves returns the JSON of v with each string and number replaced
+// with replace(value), in arrays and objects at any depth.
+func replaceLeaves(v gjson.Result, replace func(v gjson.Result) []byte) []byte {
+	switch {
+	case v.Type == gjson.String || v.Type == gjson.Number:
+		return replace(v)
+	case v.IsArray():
+		b := []byte{'['}
+		v.ForEach(func(_, e gjson.Result) bool {
+			if len(b) > 1 {
+				b = append(b, ',')
+			}
+			b = append(b, replaceLeaves(e, replace)...)
+			return true
+		})
+		return append(b, ']')
+	case v.IsObject():
+		b := []byte{'{'}
+		v.ForEach(func(k, e gjson.Result) bool {
+			if len(b) > 1 {
+				b = append(b, ',')
+			}
+			b = append(b, k.Raw...)
+			b = append(b, ':')
+			b = append(b, replaceLeaves(e, replace)...)
+			return true
+		})
+		return append(b, '}')
+	}
+	return []byte(v.Raw)
+}
+
+var (
+	fakeFirstNames = []string{"James", "Mary", "Robert", "Patricia", "John", "Jennifer", "Michael", "Linda", "David", "Elizabeth", "William", "Barbara", "Richard", "Susan", "Joseph", "Jessica", "Thomas", "Sarah", "Charles", "Karen", "Daniel", "Nancy", "Matthew", "Lisa", "Anthony", "Betty", "Mark", "Sandra", "Paul", "Ashley", "Steven", "Emily"}
+	fakeLastNames  = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Rodriguez", "Martinez", "Hernandez", "Lopez", "Gonzalez", "Wilson", "Anderson", "Thomas", "Taylor", "Moore", "Jackson", "Martin", "Lee", "Perez", "Thompson", "White", "Harris", "Sanchez", "Clark", "Ramirez", "Lewis", "Robinson", "Walker", "Young"}
+)
+
+// fakeFirstName returns a first name derived from v.
+func fakeFirstName(v string) string {
+	return fakeFirstNames[binary.BigEndian.Uint32(digest(v, 4))%uint32(len(fakeFirstNames))]
+}
+
+// fakeLastName returns a last name derived from v.
+func fakeLastName(v string) string {
+	return fakeLastNames[binary.BigEndian.Uint32(digest(v, 8)[4:])%uint32(len(fakeLastNames))]
+}
+
+// fakers create a fake value of a kind from the digest of the original.
+var fakers = map[string]func(v string) string{
+	"first_name": fakeFirstName,
+	"last_name":  fakeLastName,
+	"person_name": func(v string) string {
+		return fakeFirstName(v) + " " + fakeLastName(v)
+	},
+	"email": func(v string) string {
+		return strings.ToLower(fakeFirstName(v)+"."+fakeLastName(v)) + fmt.Sprintf("%d@example.com", digest(v, 9)[8]%100)
+	},
+	"phone": func(v string) string {
+		n := binary.BigEndian.Uint32(digest(v, 4))
+		return fmt.Sprintf("555-%03d-%04d", n/10000%1000, n%10000)
+	},
+	"ipv4": func(v string) string {
+		d := digest(v, 3)
+		// An address in 10.0.0.0/8.
+		return fmt.Sprintf("10.%d.%d.%d", d[0], d[1], d[2])
+	},
+}
+
+// newFakeTransform replaces the strings and numbers at a dot path with a
+// fake value of a kind, given as path=kind, including those in an array or
+// object at the path.
+func newFakeTransform(arg string) (transformer, error) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("use fake:path=kind")
+	}
+	fake, ok := fakers[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %s", parts[1])
+	}
+	return newAnonymizeTransform(parts[0], func(v gjson.Result) []byte {
+		b, _ := json.Marshal(fake(v.String()))
+		return b
+	})
+}
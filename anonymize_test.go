@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestHashDigits(t *testing.T) {
+	for _, n := range []string{"0", "7", "-3", "12345", "-0.5", "0.001", "1.5e10", "-2.25E-7", "3e+2", "1000000000000000000000"} {
+		h := string(hashDigits(n))
+		if !gjson.Valid(h) || gjson.Parse(h).Type != gjson.Number {
+			t.Errorf("hashDigits(%s) = %s, not a JSON number", n, h)
+			continue
+		}
+		if len(h) != len(n) {
+			t.Errorf("hashDigits(%s) = %s, want the same length", n, h)
+		}
+		if i := strings.IndexAny(n, "eE"); i >= 0 && h[i:] != n[i:] {
+			t.Errorf("hashDigits(%s) = %s, want the exponent kept", n, h)
+		}
+		if h != string(hashDigits(n)) {
+			t.Errorf("hashDigits(%s) is not deterministic", n)
+		}
+	}
+}
+
+func TestHashTransform(t *testing.T) {
+	defer func(k string) { *hashKey = k }(*hashKey)
+	*hashKey = ""
+	if _, err := newHashTransform("email"); err == nil {
+		t.Errorf("newHashTransform without --hash-key: want an error")
+	}
+	*hashKey = "secret"
+
+	doc := `{"emails":["a@x.org","b@y.org"],"user":{"name":"Ann","ids":[12,{"n":"x"}]},"other":{"email":"c@z.org"},"keep":"k"}`
+	for _, path := range []string{"emails", "user", "*.email"} {
+		tr, err := newHashTransform(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, keep, err := tr.Transform([]byte(doc))
+		if err != nil || !keep || !gjson.ValidBytes(out) {
+			t.Fatalf("hash:%s: %s, %v, %v", path, out, keep, err)
+		}
+		for _, clear := range map[string][]string{"emails": {"a@x.org", "b@y.org"}, "user": {"Ann", "12", `"x"`}, "*.email": {"c@z.org"}}[path] {
+			if strings.Contains(string(out), clear) {
+				t.Errorf("hash:%s: %s still has %s", path, out, clear)
+			}
+		}
+		if gjson.GetBytes(out, "keep").String() != "k" {
+			t.Errorf("hash:%s: %s changed another field", path, out)
+		}
+	}
+
+	tr, err := newFakeTransform("emails=email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, err := tr.Transform([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := gjson.GetBytes(out, "emails"); !v.IsArray() || len(v.Array()) != 2 || !strings.HasSuffix(v.Array()[0].String(), "@example.com") {
+		t.Errorf("fake:emails=email: %s, want an array of fake emails", out)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Buffering between reading and writing documents.
+var (
+	bufferDocs  = app.Flag("buffer-docs", "Number of documents to buffer between reading and writing, so a slow moment on one side does not stall the other (0 to hand each document over directly)").Default("0").Int()
+	bufferBytes = app.Flag("buffer-bytes", "Maximum size of the documents buffered between reading and writing (e.g. 64MB, 0 for no limit)").Default("0").Bytes()
+)
+
+// bufferDocsDefault is the number of documents buffered when only
+// --buffer-bytes is set.
+const bufferDocsDefault = 65536
+
+// hitSize returns the size of a document sent on a channel, for
+// --buffer-bytes.
+func hitSize(h interface{}) int64 {
+	switch hit := h.(type) {
+	case rawHit:
+		return int64(len(hit))
+	case []byte:
+		return int64(len(hit))
+	case elastic.SearchHit:
+		return int64(len(hit.Source))
+	}
+	return 1
+}
+
+// bufferHits buffers up to --buffer-docs documents sent on the channel, of
+// at most --buffer-bytes in total, and returns the channel the documents
+// are sent on. If buffering is off, the channel is returned as is.
+func bufferHits(ctx context.Context, g *errgroup.Group, hits chan interface{}) chan interface{} {
+	limit := int64(*bufferBytes)
+	n := *bufferDocs
+	if n <= 0 && limit <= 0 {
+		return hits
+	}
+	if n <= 0 {
+		n = bufferDocsDefault
+	}
+	var mem *semaphore.Weighted
+	if limit > 0 {
+		mem = semaphore.NewWeighted(limit)
+	}
+	// weight returns what a document counts against the memory limit. A
+	// document larger than the limit takes all of it.
+	weight := func(h interface{}) int64 {
+		if s := hitSize(h); s < limit {
+			return s
+		}
+		return limit
+	}
+
+	queue := make(chan interface{}, n)
+	g.Go(func() error {
+		defer close(queue)
+		for h := range hits {
+			if mem != nil {
+				if err := mem.Acquire(ctx, weight(h)); err != nil {
+					return err
+				}
+			}
+			select {
+			case queue <- h:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+
+	out := make(chan interface{})
+	g.Go(func() error {
+		defer close(out)
+		for h := range queue {
+			select {
+			case out <- h:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if mem != nil {
+				mem.Release(weight(h))
+			}
+		}
+		return nil
+	})
+	return out
+}
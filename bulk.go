@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+)
+
+// Bulk requests are retried on these statuses, and the documents in a bulk
+// response with them are sent again.
+var bulkRetryStatus = map[int]bool{
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+const (
+	bulkRetries       = 8
+	bulkFlushInterval = time.Second
+	// defaultBatchSize is the default of --batch-size.
+	defaultBatchSize = 1000
+)
+
+// bulkBuffers are reused for the bodies of bulk requests.
+var bulkBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// bulkBatch is the body of a bulk request. It is built from the raw bytes
+// of the documents, which are copied into the body and never decoded.
+type bulkBatch struct {
+	body *bytes.Buffer
+	// ends are the offsets in body at which each document's lines end.
+	ends []int
+	// progress is the amount to add to the progress bar once sent.
+	progress int64
+	// actions are the action of each document, kept with --skip-existing.
+	actions []bulkAction
+	// failed are the lines of the documents that failed for good, in the
+	// order of the items returned by sendBulk.
+	failed []string
+}
+
+// bulkAction is the action line of a document in a bulk request.
+type bulkAction struct {
+	Index    string `json:"_index"`
+	Type     string `json:"_type,omitempty"`
+	ID       string `json:"_id,omitempty"`
+	Routing  string `json:"routing,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+// newBulkBatch returns an empty batch, with a body buffer from the pool.
+func newBulkBatch() *bulkBatch {
+	return &bulkBatch{body: bulkBuffers.Get().(*bytes.Buffer)}
+}
+
+// release returns the body buffer of the batch to the pool.
+func (b *bulkBatch) release() {
+	b.body.Reset()
+	bulkBuffers.Put(b.body)
+	b.body = nil
+}
+
+// len returns the number of documents in the batch.
+func (b *bulkBatch) len() int {
+	return len(b.ends)
+}
+
+// add appends the document of a hit to the batch, to be indexed, or
+// updated with --mode=upsert.
+func (b *bulkBatch) add(index string, hit elastic.SearchHit) error {
+	meta := bulkAction{Index: index, Type: bulkType(hit, dstCluster), ID: hit.Id, Routing: hit.Routing}
+	op := "index"
+	upsert := *importMode == "upsert" && hit.Id != ""
+	switch {
+	case upsert:
+		op = "update"
+	case *importOpType == "create":
+		op = "create"
+	}
+	if !upsert {
+		meta.Pipeline = *importPipeline
+	}
+	action, err := json.Marshal(map[string]bulkAction{op: meta})
+	if err != nil {
+		return err
+	}
+	start := b.body.Len()
+	b.body.Write(action)
+	b.body.WriteByte('\n')
+	if upsert {
+		b.body.WriteString(`{"doc":`)
+	}
+	if bytes.IndexByte(hit.Source, '\n') >= 0 {
+		// Documents are one line each in a bulk request.
+		if err = json.Compact(b.body, hit.Source); err != nil {
+			b.body.Truncate(start)
+			return fmt.Errorf("invalid document %s: %s", hit.Id, err.Error())
+		}
+	} else {
+		b.body.Write(hit.Source)
+	}
+	if upsert {
+		b.body.WriteString(`,"doc_as_upsert":true}`)
+	}
+	b.body.WriteByte('\n')
+	b.ends = append(b.ends, b.body.Len())
+	if *importSkipExist {
+		b.actions = append(b.actions, meta)
+	}
+	return nil
+}
+
+// skipExisting removes the documents that exist in the cluster from the
+// batch, looked up by _id with a multi get, and counts them. Documents
+// without an _id are always kept.
+func (b *bulkBatch) skipExisting(ctx context.Context, client *elastic.Client) error {
+	mget := client.MultiGet().Realtime(true)
+	var checked []int
+	for i, a := range b.actions {
+		if a.ID == "" {
+			continue
+		}
+		item := elastic.NewMultiGetItem().Index(a.Index).Id(a.ID).FetchSource(elastic.NewFetchSourceContext(false))
+		if a.Type != "" {
+			item.Type(a.Type)
+		}
+		if a.Routing != "" {
+			item.Routing(a.Routing)
+		}
+		mget.Add(item)
+		checked = append(checked, i)
+	}
+	if len(checked) == 0 {
+		return nil
+	}
+	res, err := mget.Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error looking up existing documents: %s", err.Error())
+	}
+	exists := map[int]bool{}
+	for j, doc := range res.Docs {
+		if j < len(checked) && doc.Found {
+			exists[checked[j]] = true
+		}
+	}
+	if len(exists) == 0 {
+		return nil
+	}
+	body := bulkBuffers.Get().(*bytes.Buffer)
+	var ends []int
+	var actions []bulkAction
+	start := 0
+	for i, end := range b.ends {
+		if !exists[i] {
+			body.Write(b.body.Bytes()[start:end])
+			ends = append(ends, body.Len())
+			actions = append(actions, b.actions[i])
+		}
+		start = end
+	}
+	b.release()
+	b.body, b.ends, b.actions = body, ends, actions
+	atomic.AddInt64(&existingCount, int64(len(exists)))
+	return nil
+}
+
+// sendBulk sends a batch, retrying the request and the documents rejected
+// with a retryable status with an exponential backoff. It returns the items
+// of the documents that failed for good, whose lines are kept in b.failed.
+func sendBulk(ctx context.Context, client *elastic.Client, b *bulkBatch) ([]*elastic.BulkResponseItem, error) {
+	body := b.body.String()
+	ends := b.ends
+	b.failed = nil
+	// The documents that failed for good, over all the attempts.
+	var failed []*elastic.BulkResponseItem
+	wait := 200 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+			Method:      "POST",
+			Path:        "/_bulk",
+			Body:        body,
+			ContentType: "application/x-ndjson",
+		})
+		var retry strings.Builder
+		var retryEnds []int
+		if err == nil {
+			var r elastic.BulkResponse
+			if err = json.Unmarshal(res.Body, &r); err != nil {
+				return nil, fmt.Errorf("error parsing bulk response: %s", err.Error())
+			}
+			start := 0
+			for i, item := range r.Items {
+				if i >= len(ends) {
+					break
+				}
+				for _, result := range item {
+					switch {
+					case result.Status >= 200 && result.Status <= 299:
+					case bulkRetryStatus[result.Status] && attempt < bulkRetries:
+						retry.WriteString(body[start:ends[i]])
+						retryEnds = append(retryEnds, retry.Len())
+					case isExisting(result):
+						atomic.AddInt64(&existingCount, 1)
+					default:
+						failed = append(failed, result)
+						b.failed = append(b.failed, body[start:ends[i]])
+					}
+				}
+				start = ends[i]
+			}
+			if len(retryEnds) == 0 {
+				return failed, nil
+			}
+		} else if e, ok := err.(*elastic.Error); ctx.Err() != nil || attempt >= bulkRetries || ok && !bulkRetryStatus[e.Status] {
+			return nil, fmt.Errorf("error bulk indexing: %s", err.Error())
+		}
+		if len(retryEnds) > 0 {
+			body, ends = retry.String(), retryEnds
+		}
+		if *debug {
+			logger.Printf("retrying bulk request of %d documents in %s\n", len(ends), wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if wait *= 2; wait > 10*time.Second {
+			wait = 10 * time.Second
+		}
+	}
+}
+
+// isExisting returns whether a bulk item failed because the document
+// already exists, with --op-type=create.
+func isExisting(item *elastic.BulkResponseItem) bool {
+	return *importOpType == "create" && item.Status == http.StatusConflict
+}
+
+// batchSize returns the number of documents in each bulk request. The
+// import flags are not set by the other commands, which use the default.
+func batchSize() int {
+	if *importBatch > 0 {
+		return *importBatch
+	}
+	return defaultBatchSize
+}
+
+// writeDataToElastic bulk indexes each document sent on channel into
+// elasticsearch, with one bulk request in flight per CPU. A batch is sent
+// once it has --batch-size documents, or after a second otherwise.
+func writeDataToElastic(ctx context.Context, g *errgroup.Group, client *elastic.Client, dstIndex, idField string, hits chan interface{}) error {
+	batches := make(chan *bulkBatch)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		g.Go(func() error {
+			for b := range batches {
+				if *importSkipExist {
+					if err := b.skipExisting(ctx, client); err != nil {
+						b.release()
+						return err
+					}
+					if b.len() == 0 {
+						b.release()
+						bar.Add64(b.progress)
+						continue
+					}
+				}
+				failed, err := sendBulk(ctx, client, b)
+				b.release()
+				if err != nil {
+					return err
+				}
+				if len(failed) > 0 {
+					if err = rejectedDocs(failed, b.failed); err != nil {
+						return err
+					}
+				}
+				bar.Add64(b.progress)
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		defer close(batches)
+		ticker := time.NewTicker(bulkFlushInterval)
+		defer ticker.Stop()
+		b := newBulkBatch()
+		flush := func() error {
+			if b.len() == 0 {
+				// Only skipped lines, count them as done.
+				bar.Add64(b.progress)
+				b.progress = 0
+				return nil
+			}
+			select {
+			case batches <- b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			b = newBulkBatch()
+			return nil
+		}
+		for {
+			var h interface{}
+			var ok bool
+			select {
+			case h, ok = <-hits:
+			case <-ticker.C:
+				if err := flush(); err != nil {
+					return err
+				}
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if !ok {
+				return flush()
+			}
+
+			// Lines read from a file or documents exported from another index.
+			var res elastic.SearchHit
+			var err error
+			switch hit := h.(type) {
+			case []byte:
+				b.progress += int64(len(hit))
+				if len(bytes.TrimSpace(hit)) == 0 {
+					// Blank lines are not documents.
+					atomic.AddInt64(&docCount, -1)
+					continue
+				}
+				if res, err = parseHit(hit, idField); err != nil {
+					if err = malformedDoc(hit, err); err != nil {
+						return err
+					}
+					continue
+				}
+			case rawHit:
+				b.progress++
+				if res, err = parseHit(hit, ""); err != nil {
+					return err
+				}
+			case elastic.SearchHit:
+				b.progress++
+				res = hit
+			}
+			if res, err = applyDocType(res, dstCluster); err != nil {
+				return err
+			}
+			i, err := hitIndex(ctx, dstIndex, res)
+			if err != nil {
+				return err
+			}
+			if err = b.add(i, res); err != nil {
+				if err = malformedDoc(res.Source, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if b.len() >= batchSize() {
+				if err = flush(); err != nil {
+					return err
+				}
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/errgroup"
+)
+
+// Parallel encoding of export files.
+var (
+	writeWorkers   = app.Flag("write-workers", "Number of goroutines encoding and compressing the lines of an export file (default: the number of CPUs)").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	writeUnordered = app.Flag("unordered", "Write the lines of an export file in the order they are encoded rather than the order they are read, which is faster with several --write-workers").Bool()
+)
+
+const (
+	// fileChunkSize is the size of the chunks of lines encoded by each
+	// worker. With gzip each chunk is a separate gzip member of the file.
+	fileChunkSize      = 1 << 20
+	chunkFlushInterval = time.Second
+)
+
+// fileChunk is a chunk of the lines of an export file, encoded (and
+// compressed) by a worker.
+type fileChunk struct {
+	hits []interface{}
+	// docs is the number of documents in the chunk, size their size.
+	docs int
+	size int
+	data *bytes.Buffer
+	done chan struct{}
+}
+
+// chunkBuffers and gzipWriters are reused to encode chunks.
+var (
+	chunkBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+)
+
+// encode writes the lines of the chunk to its data, as gzip if doGzip is
+// set. If sourceOnly is set, only the _source of each hit is written.
+func (c *fileChunk) encode(doGzip, sourceOnly bool) error {
+	c.data = chunkBuffers.Get().(*bytes.Buffer)
+	var w io.Writer = c.data
+	var gzw *gzip.Writer
+	if doGzip {
+		gzw = gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gzw)
+		gzw.Reset(c.data)
+		w = gzw
+	}
+	for _, h := range c.hits {
+		// Hits are written as returned by Elasticsearch.
+		line := h.(rawHit)
+		if sourceOnly {
+			if src := gjson.GetBytes(line, "_source"); src.Exists() {
+				line = line[src.Index : src.Index+len(src.Raw)]
+			} else {
+				line = rawHit("{}")
+			}
+		}
+		w.Write(line)
+		w.Write([]byte{'\n'})
+	}
+	c.docs = len(c.hits)
+	c.hits = nil
+	if gzw != nil {
+		return gzw.Close()
+	}
+	return nil
+}
+
+// release returns the data buffer of the chunk to the pool.
+func (c *fileChunk) release() {
+	c.data.Reset()
+	chunkBuffers.Put(c.data)
+	c.data = nil
+}
+
+// encodeChunks groups the documents sent on channel into chunks that are
+// encoded by --write-workers goroutines, and returns the channel the
+// encoded chunks are sent on, in the order they were read unless
+// --unordered is set. A partial chunk is sent every second, so the output
+// keeps up while following an index.
+func encodeChunks(ctx context.Context, g *errgroup.Group, doGzip, sourceOnly bool, hits chan interface{}) chan *fileChunk {
+	workers := *writeWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan *fileChunk, workers)
+	// The chunks in the order they were read, or as they are encoded.
+	out := make(chan *fileChunk, workers)
+	ordered := !*writeUnordered
+	var encoded chan *fileChunk
+	if ordered {
+		encoded = make(chan *fileChunk, workers)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		g.Go(func() error {
+			defer wg.Done()
+			for c := range work {
+				if err := c.encode(doGzip, sourceOnly); err != nil {
+					return err
+				}
+				close(c.done)
+				if !ordered {
+					select {
+					case out <- c:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if !ordered {
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+	} else {
+		g.Go(func() error {
+			defer close(out)
+			for c := range encoded {
+				select {
+				case <-c.done:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}
+
+	g.Go(func() error {
+		defer close(work)
+		if ordered {
+			defer close(encoded)
+		}
+		ticker := time.NewTicker(chunkFlushInterval)
+		defer ticker.Stop()
+		c := &fileChunk{done: make(chan struct{})}
+		send := func() error {
+			if len(c.hits) == 0 {
+				return nil
+			}
+			select {
+			case work <- c:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if ordered {
+				select {
+				case encoded <- c:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			c = &fileChunk{done: make(chan struct{})}
+			return nil
+		}
+		for {
+			select {
+			case h, ok := <-hits:
+				if !ok {
+					return send()
+				}
+				c.hits = append(c.hits, h)
+				c.size += int(hitSize(h))
+				if c.size >= fileChunkSize {
+					if err := send(); err != nil {
+						return err
+					}
+				}
+			case <-ticker.C:
+				if err := send(); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+	return out
+}
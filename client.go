@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Options of the connections to Elasticsearch.
+var (
+	httpCompress    = app.Flag("http-compress", "Gzip the bodies of the requests to Elasticsearch, such as bulk requests (responses are compressed whenever the cluster allows it)").Bool()
+	maxConnsPerHost = app.Flag("max-conns-per-host", "Maximum number of connections to each Elasticsearch node (0 for no limit)").Default("0").Int()
+	maxIdlePerHost  = app.Flag("max-idle-conns-per-host", "Number of idle connections to keep open to each Elasticsearch node for reuse (default: the number of CPUs, one per bulk worker)").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	connTimeout     = app.Flag("conn-timeout", "How long to wait for a connection to Elasticsearch to be established").Default("30s").Duration()
+	responseTimeout = app.Flag("response-timeout", "How long to wait for the response headers of a request to Elasticsearch once it is sent (0 for no limit)").Default("0").Duration()
+	keepAlive       = app.Flag("keep-alive", "Interval of the TCP keep-alive probes on connections to Elasticsearch (negative to disable)").Default("30s").Duration()
+	sourceHeaders   = app.Flag("source-header", "HTTP header to send with every request to the source cluster, e.g. 'X-Tenant: acme' (repeatable)").Strings()
+	destHeaders     = app.Flag("dest-header", "HTTP header to send with every request to the destination cluster, e.g. 'Authorization: Bearer ...' (repeatable)").Strings()
+	proxyURL        = app.Flag("proxy", "Proxy to connect to Elasticsearch and Kibana through (http://, https:// or socks5://host:port, by default the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used)").URL()
+	sourceURLs      = app.Flag("source-urls", "Other nodes of the source cluster to spread the requests over, besides the source URL (repeatable)").Strings()
+	destURLs        = app.Flag("dest-urls", "Other nodes of the destination cluster to spread the requests over, besides the destination URL (repeatable)").Strings()
+	sniff           = app.Flag("sniff", "Discover the other nodes of the clusters and spread the requests over them (the nodes must be reachable at their published addresses)").Bool()
+	healthcheck     = app.Flag("healthcheck", "Regularly check the nodes of the clusters and stop sending requests to the ones that are down").Bool()
+	requestTimeout  = app.Flag("request-timeout", "How long a single request to Elasticsearch may take, including reading the response (0 for no limit)").Default("0").Duration()
+	idleConnTimeout = app.Flag("idle-conn-timeout", "How long an idle connection to Elasticsearch is kept open").Default("90s").Duration()
+	sourceCACert    = app.Flag("source-ca-cert", "PEM file of the CA certificates to verify the TLS certificate of the source cluster with, instead of the system ones").ExistingFile()
+	destCACert      = app.Flag("dest-ca-cert", "PEM file of the CA certificates to verify the TLS certificate of the destination cluster with, instead of the system ones").ExistingFile()
+	sourceInsecure  = app.Flag("source-insecure", "Do not verify the TLS certificate of the source cluster").Bool()
+	destInsecure    = app.Flag("dest-insecure", "Do not verify the TLS certificate of the destination cluster").Bool()
+)
+
+// Sides of a transfer, for the flags that apply to only one of them.
+const (
+	sourceSide = "source"
+	destSide   = "dest"
+)
+
+// clientOptions returns the options of the connections to the source or
+// destination cluster at url set by the flags.
+func clientOptions(rawURL, side string) ([]elastic.ClientOptionFunc, error) {
+	urls, headers := append([]string{rawURL}, *sourceURLs...), *sourceHeaders
+	if side == destSide {
+		urls, headers = append([]string{rawURL}, *destURLs...), *destHeaders
+	}
+	h, err := parseHeaders(headers)
+	if err != nil {
+		return nil, err
+	}
+	return []elastic.ClientOptionFunc{
+		elastic.SetURL(urls...),
+		elastic.SetSniff(*sniff),
+		elastic.SetHealthcheck(*healthcheck),
+		elastic.SetGzip(*httpCompress),
+		elastic.SetHeaders(h),
+	}, nil
+}
+
+// parseHeaders parses HTTP headers given as Name: value.
+func parseHeaders(headers []string) (http.Header, error) {
+	h := http.Header{}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid header %q: use 'Name: value'", header)
+		}
+		h.Add(name, strings.TrimSpace(parts[1]))
+	}
+	return h, nil
+}
+
+// newHTTPTransport returns the HTTP transport to the source or destination
+// cluster, tuned by the flags. The defaults of Go keep only two idle
+// connections per host, which makes concurrent bulk workers open a new
+// connection for most requests.
+func newHTTPTransport(side string) (*http.Transport, error) {
+	t := proxyTransport()
+	caCert, insecure := *sourceCACert, *sourceInsecure
+	if side == destSide {
+		caCert, insecure = *destCACert, *destInsecure
+	}
+	if caCert != "" || insecure {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+	}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig.RootCAs = x509.NewCertPool()
+		if !t.TLSClientConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caCert)
+		}
+	}
+	t.DialContext = (&net.Dialer{Timeout: *connTimeout, KeepAlive: *keepAlive}).DialContext
+	t.MaxConnsPerHost = *maxConnsPerHost
+	t.MaxIdleConnsPerHost = *maxIdlePerHost
+	if t.MaxIdleConns < t.MaxIdleConnsPerHost {
+		t.MaxIdleConns = t.MaxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = *idleConnTimeout
+	t.ResponseHeaderTimeout = *responseTimeout
+	return t, nil
+}
+
+// proxyTransport returns a default HTTP transport that connects through
+// --proxy if set, or else the proxy from the environment.
+func proxyTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if *proxyURL != nil {
+		t.Proxy = http.ProxyURL(*proxyURL)
+	}
+	return t
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+var (
+	// Convert an export file to another format or compression
+	convertCmd  = app.Command("convert", "Convert an export file to another format or compression, without a cluster: NDJSON, a JSON array or CSV, compressed with gzip ('.gz') or zstd ('.zst') by suffix")
+	convertSrc  = convertCmd.Arg("source", "File to convert").Required().ExistingFile()
+	convertDst  = convertCmd.Arg("dest", "File to write").Required().String()
+	convertFrom = convertCmd.Flag("from", "Format of the source (default: csv for a '.csv' file, ndjson otherwise)").Enum("ndjson", "array", "csv")
+	convertTo   = convertCmd.Flag("to", "Format of the destination (default: csv for a '.csv' file, ndjson otherwise)").Enum("ndjson", "array", "csv")
+)
+
+// csvMeta are the metadata fields of a document that have their own CSV
+// column, rather than a field of the _source.
+var csvMeta = []string{"_index", "_id", "_routing"}
+
+// csvRawTypes are the field types whose CSV values are JSON, rather than
+// strings, when converting back from CSV.
+var csvRawTypes = map[string]bool{
+	"long": true, "integer": true, "short": true, "byte": true, "unsigned_long": true,
+	"double": true, "float": true, "half_float": true, "scaled_float": true,
+	"boolean": true, "object": true, "nested": true, "flattened": true,
+}
+
+func doConvert() error {
+	from, to := *convertFrom, *convertTo
+	if from == "" {
+		from = fileFormat(*convertSrc)
+	}
+	if to == "" {
+		to = fileFormat(*convertDst)
+	}
+	if *convertSrc == *convertDst {
+		return fmt.Errorf("the source and destination are the same file")
+	}
+	// The field types of the mappings saved with the source, if any.
+	types := map[string]string{}
+	if m, err := readMappingsFromFile(*convertSrc); err == nil {
+		for _, im := range gjson.ParseBytes(m).Map() {
+			fieldTypes(im.Get("mappings").Value(), "", types)
+		}
+	}
+
+	in, err := openExportFile(*convertSrc)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createExportFile(*convertDst)
+	if err != nil {
+		return err
+	}
+	var w docWriter
+	switch to {
+	case "array":
+		w = &arrayWriter{w: out}
+	case "csv":
+		w = &csvWriter{w: csv.NewWriter(out), types: types}
+	default:
+		w = &ndjsonWriter{w: out}
+	}
+	n := 0
+	write := func(doc []byte) error {
+		n++
+		return w.write(doc)
+	}
+	switch from {
+	case "array":
+		err = readArray(in, write)
+	case "csv":
+		err = readCSV(in, types, write)
+	default:
+		err = readNDJSON(in, write)
+	}
+	if err == nil {
+		err = w.close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error converting %s: %s", *convertSrc, err.Error())
+	}
+	if err = copyCompanions(*convertSrc, *convertDst); err != nil {
+		return err
+	}
+	logger.Printf("converted %d documents from %s (%s) to %s (%s)\n", n, *convertSrc, from, *convertDst, to)
+	return nil
+}
+
+// fileFormat returns the format of a file by its suffix.
+func fileFormat(file string) string {
+	if strings.HasSuffix(trimCompression(file), ".csv") {
+		return "csv"
+	}
+	return "ndjson"
+}
+
+// trimCompression returns the name of a file without its compression
+// suffix.
+func trimCompression(file string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(file, ".gz"), ".zst")
+}
+
+// companionKinds are the suffixes of the files saved next to an export
+// besides its mappings (see companionFile).
+var companionKinds = []string{"settings", "ilm", "pipelines"}
+
+// copyCompanions copies the mappings, settings, ILM and pipelines files
+// saved next to an export to the names that go with another file, so the
+// converted file can be imported.
+func copyCompanions(src, dst string) error {
+	files := map[string]string{mappingsFileName(src): mappingsFileName(dst)}
+	for _, suffix := range companionKinds {
+		files[companionFile(src, suffix)] = companionFile(dst, suffix)
+	}
+	for from, to := range files {
+		if from == to {
+			continue
+		}
+		b, err := ioutil.ReadFile(from)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = ioutil.WriteFile(to, b, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("error copying %s to %s: %s", from, to, err.Error())
+		}
+	}
+	return nil
+}
+
+// readNDJSON calls fn with each document of a file with one per line.
+func readNDJSON(r io.Reader, fn func([]byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readArray calls fn with each document of a JSON array, on a single line.
+func readArray(r io.Reader, fn func([]byte) error) error {
+	d := json.NewDecoder(r)
+	if t, err := d.Token(); err != nil || t != json.Delim('[') {
+		return fmt.Errorf("not a JSON array")
+	}
+	var doc bytes.Buffer
+	for d.More() {
+		var raw json.RawMessage
+		if err := d.Decode(&raw); err != nil {
+			return err
+		}
+		doc.Reset()
+		if err := json.Compact(&doc, raw); err != nil {
+			return err
+		}
+		if err := fn(doc.Bytes()); err != nil {
+			return err
+		}
+	}
+	_, err := d.Token()
+	return err
+}
+
+// readCSV calls fn with a document for each row of a CSV file with a
+// header. The _index, _id and _routing columns are the metadata of the
+// document, the other columns are fields of its _source, by dot path.
+// Empty values are left out. Values are strings, except for the fields
+// the mappings type as numbers, booleans or objects, or, without a type,
+// values that are valid JSON other than a string.
+func readCSV(r io.Reader, types map[string]string, fn func([]byte) error) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta := false
+	for _, col := range header {
+		for _, m := range csvMeta {
+			meta = meta || col == m
+		}
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		doc, source := []byte("{}"), []byte("{}")
+		for i, v := range row {
+			if v == "" || i >= len(header) {
+				continue
+			}
+			col := header[i]
+			switch {
+			case col == "_index" || col == "_id" || col == "_routing":
+				doc, err = sjson.SetBytes(doc, col, v)
+			case csvRaw(v, types[col]):
+				source, err = sjson.SetRawBytes(source, sjsonEscape(col), []byte(v))
+			default:
+				source, err = sjson.SetBytes(source, sjsonEscape(col), v)
+			}
+			if err != nil {
+				return fmt.Errorf("error setting %s: %s", col, err.Error())
+			}
+		}
+		if !meta {
+			doc = source
+		} else if doc, err = sjson.SetRawBytes(doc, "_source", source); err != nil {
+			return err
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// csvRaw returns whether a CSV value of a field of a type is added to a
+// document as JSON rather than as a string.
+func csvRaw(v, t string) bool {
+	if !gjson.Valid(v) {
+		return false
+	}
+	if t != "" {
+		return csvRawTypes[t] || v[0] == '['
+	}
+	return v[0] != '"'
+}
+
+// sjsonEscape escapes the sjson wildcard characters in a dot path.
+func sjsonEscape(path string) string {
+	r := strings.NewReplacer("*", `\*`, "?", `\?`, "#", `\#`)
+	return r.Replace(path)
+}
+
+// docWriter writes documents in a format.
+type docWriter interface {
+	write(doc []byte) error
+	close() error
+}
+
+// ndjsonWriter writes one document per line.
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func (n *ndjsonWriter) write(doc []byte) error {
+	if _, err := n.w.Write(doc); err != nil {
+		return err
+	}
+	_, err := n.w.Write([]byte{'\n'})
+	return err
+}
+
+func (n *ndjsonWriter) close() error {
+	return nil
+}
+
+// arrayWriter writes the documents as a JSON array, one per line.
+type arrayWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (a *arrayWriter) write(doc []byte) error {
+	sep := ",\n"
+	if a.count == 0 {
+		sep = "[\n"
+	}
+	a.count++
+	if _, err := io.WriteString(a.w, sep); err != nil {
+		return err
+	}
+	_, err := a.w.Write(doc)
+	return err
+}
+
+func (a *arrayWriter) close() error {
+	end := "\n]\n"
+	if a.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
+
+// csvWriter writes the documents as CSV, with a column for the metadata
+// of the documents and for each field. The columns are the fields of the
+// mappings if there are any, or else of the first document. Arrays and
+// objects are written as JSON.
+type csvWriter struct {
+	w     *csv.Writer
+	types map[string]string
+	cols  []string
+	meta  bool
+	row   []string
+}
+
+func (c *csvWriter) write(doc []byte) error {
+	source := doc
+	if s := gjson.GetBytes(doc, "_source"); s.Exists() {
+		source = []byte(s.Raw)
+	}
+	if c.cols == nil {
+		c.meta = len(source) != len(doc)
+		c.cols = csvColumns(c.types, source)
+		header := c.cols
+		if c.meta {
+			header = append(append([]string{}, csvMeta...), c.cols...)
+		}
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	c.row = c.row[:0]
+	if c.meta {
+		for _, m := range csvMeta {
+			c.row = append(c.row, gjson.GetBytes(doc, m).String())
+		}
+	}
+	for _, col := range c.cols {
+		r := gjson.GetBytes(source, gjsonEscape(col))
+		switch {
+		case !r.Exists() || r.Type == gjson.Null:
+			c.row = append(c.row, "")
+		case r.Type == gjson.String:
+			c.row = append(c.row, r.Str)
+		default:
+			c.row = append(c.row, r.Raw)
+		}
+	}
+	return c.w.Write(c.row)
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvColumns returns the fields written to CSV: the fields of the mappings
+// other than objects, or the fields of the document if there are none.
+// Fields whose names have a dot are not supported.
+func csvColumns(types map[string]string, doc []byte) []string {
+	var cols []string
+	for field, t := range types {
+		if t != "object" && t != "nested" {
+			cols = append(cols, field)
+		}
+	}
+	if len(cols) == 0 {
+		cols = documentFields(gjson.ParseBytes(doc), "")
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// documentFields returns the dot paths of the leaf fields of a document.
+func documentFields(doc gjson.Result, prefix string) []string {
+	var fields []string
+	doc.ForEach(func(k, v gjson.Result) bool {
+		if v.IsObject() {
+			fields = append(fields, documentFields(v, prefix+k.String()+".")...)
+		} else {
+			fields = append(fields, prefix+k.String())
+		}
+		return true
+	})
+	return fields
+}
+
+// exportFile is an export file opened for reading, decompressed if it
+// ends in .gz or .zst.
+type exportFile struct {
+	io.Reader
+	f   *os.File
+	dec io.Closer
+}
+
+// openExportFile opens an export file for reading.
+func openExportFile(file string) (*exportFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file %s: %s", file, err.Error())
+	}
+	return readExportFile(f)
+}
+
+// readExportFile reads an open export file, decompressed by the suffix of
+// its name.
+func readExportFile(f *os.File) (*exportFile, error) {
+	file := f.Name()
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to read gzip file %s: %s", file, err.Error())
+		}
+		return &exportFile{Reader: gz, f: f, dec: gz}, nil
+	case strings.HasSuffix(file, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("unable to read zstd file %s: %s", file, err.Error())
+		}
+		rc := zr.IOReadCloser()
+		return &exportFile{Reader: rc, f: f, dec: rc}, nil
+	}
+	return &exportFile{Reader: f, f: f}, nil
+}
+
+// Close closes the file.
+func (e *exportFile) Close() error {
+	if e.dec != nil {
+		e.dec.Close()
+	}
+	return e.f.Close()
+}
+
+// compressedFile is a file created for writing, compressed if its name
+// ends in .gz or .zst.
+type compressedFile struct {
+	*bufio.Writer
+	f   *os.File
+	enc io.WriteCloser
+}
+
+// createExportFile creates a file to write an export to.
+func createExportFile(file string) (*compressedFile, error) {
+	f, err := createFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create destination file %s: %s", file, err.Error())
+	}
+	c := &compressedFile{f: f}
+	var w io.Writer = f
+	switch {
+	case strings.HasSuffix(file, ".gz"):
+		c.enc = gzip.NewWriter(f)
+		w = c.enc
+	case strings.HasSuffix(file, ".zst"):
+		if c.enc, err = zstd.NewWriter(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = c.enc
+	}
+	c.Writer = bufio.NewWriterSize(w, 64*1024)
+	return c, nil
+}
+
+// Close flushes and closes the file.
+func (c *compressedFile) Close() error {
+	err := c.Flush()
+	if c.enc != nil {
+		if cerr := c.enc.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// Count the documents an export would read
+	countCmd       = app.Command("count", "Count the documents of an index that an export with the same filters would read")
+	countURL       = countCmd.Flag("source-url", "Elasticsearch host (http://host:port/)").Required().URL()
+	countIndex     = countCmd.Flag("source-index", "Elasticsearch index, alias or pattern to count the documents of").Required().String()
+	countQuery     = countCmd.Flag("query", "Only count the documents matching this query: a query string such as 'status:error AND bytes:>1000', or a query of the JSON query DSL").String()
+	countTimeField = countCmd.Flag("time-field", "Elasticsearch time field to filter data on").String()
+	countTimeStart = countCmd.Flag("time-start", "The start time value to filter the data on (format: --time-format, or date math such as now-24h or now-1d/d)").String()
+	countTimeEnd   = countCmd.Flag("time-end", "The end time value to filter the data on (format: --time-format, or date math such as now or now/d)").String()
+	countTimeZone  = countCmd.Flag("time-zone", "Time zone of --time-start and --time-end, and of rounding in date math (default: UTC)").String()
+	countTimeFmt   = countCmd.Flag("time-format", "Elasticsearch date format of --time-start and --time-end, e.g. epoch_millis, epoch_second, iso8601 or a pattern").Default(defaultTimeFormat).String()
+	countPerIndex  = countCmd.Flag("per-index", "Also print the count of each index the index pattern or alias resolves to").Bool()
+)
+
+func doCount() error {
+	timeFormat = esTimeFormat(*countTimeFmt)
+	if err := setTimeZone(*countTimeZone); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, err := resolveTime(*countTimeStart, now)
+	if err != nil {
+		return err
+	}
+	end, err := resolveTime(*countTimeEnd, now)
+	if err != nil {
+		return err
+	}
+	filter, err := parseQuery(*countQuery)
+	if err != nil {
+		return err
+	}
+	q := andQuery(timeQuery(*countTimeField, start, end), filter)
+	client, src, err := connectElasticSource((*countURL).String(), *countIndex, q)
+	if err != nil {
+		return err
+	}
+	if *countPerIndex {
+		for _, index := range strings.Split(src.index, ",") {
+			counter := client.Count(index)
+			if q := andQuery(q, src.filter); q != nil {
+				counter.Query(q)
+			}
+			n, err := counter.Do(rootCtx)
+			if err != nil {
+				return fmt.Errorf("error counting documents in index %s: %s", index, err.Error())
+			}
+			fmt.Printf("%s\t%d\n", index, n)
+		}
+	}
+	fmt.Println(src.total)
+	return nil
+}
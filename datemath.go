@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayout is the default format of --time-start and --time-end, as a
+// Go layout.
+const timeLayout = "2006.01.02 15:04:05"
+
+// defaultTimeFormat is timeLayout as an Elasticsearch date format.
+const defaultTimeFormat = "yyyy.MM.dd HH:mm:ss"
+
+// timeFormat is the Elasticsearch date format of the time filter values,
+// set with --time-format.
+var timeFormat = defaultTimeFormat
+
+// timeZone is the time zone of the time filter values, set with
+// --time-zone. Values in a known format are converted to UTC; with a
+// custom format, the time zone is passed to the range query as
+// rangeTimeZone instead.
+var (
+	timeZone      = time.UTC
+	rangeTimeZone string
+)
+
+// parseTimeZone returns the location of a --time-zone, either a name such
+// as Europe/Paris or Local, or an offset such as +01:00. It is UTC if not
+// set.
+func parseTimeZone(s string) (*time.Location, error) {
+	if s == "" {
+		return time.UTC, nil
+	}
+	if s[0] == '+' || s[0] == '-' {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(s, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid --time-zone %s: use a name such as Europe/Paris or an offset such as +01:00", s)
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --time-zone %s: %s", s, err.Error())
+	}
+	return loc, nil
+}
+
+// setTimeZone sets timeZone, and rangeTimeZone if the time values cannot
+// be converted to UTC here. It must be called after timeFormat is set.
+func setTimeZone(s string) error {
+	loc, err := parseTimeZone(s)
+	if err != nil {
+		return err
+	}
+	timeZone = loc
+	if _, ok := formatTime(time.Time{}); !ok && loc != time.UTC {
+		// Elasticsearch takes names and offsets, but not Local.
+		rangeTimeZone = loc.String()
+		if loc == time.Local {
+			rangeTimeZone = time.Now().Format("-07:00")
+		}
+	}
+	return nil
+}
+
+// esTimeFormat returns the Elasticsearch date format for a --time-format,
+// which may also be iso8601.
+func esTimeFormat(format string) string {
+	if format == "iso8601" {
+		return "strict_date_optional_time"
+	}
+	return format
+}
+
+// formatTime returns t in timeFormat, in UTC, or false if it is a custom
+// format that is not known here.
+func formatTime(t time.Time) (string, bool) {
+	t = t.UTC()
+	switch timeFormat {
+	case defaultTimeFormat:
+		return t.Format(timeLayout), true
+	case "epoch_millis":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), true
+	case "epoch_second":
+		return strconv.FormatInt(t.Unix(), 10), true
+	case "strict_date_optional_time", "date_optional_time":
+		return t.Format("2006-01-02T15:04:05.000Z07:00"), true
+	}
+	return "", false
+}
+
+// resolveTime returns the time of a date math expression relative to now,
+// such as now-24h or now-1d/d, in timeFormat. Other times are converted
+// from timeZone to UTC if they are in a known format. Values in a custom
+// timeFormat are returned as is for Elasticsearch to resolve.
+func resolveTime(s string, now time.Time) (string, error) {
+	if !isDateMath(s) {
+		return localTime(s), nil
+	}
+	t, err := evalDateMath(s, now)
+	if err != nil {
+		return "", err
+	}
+	if formatted, ok := formatTime(t); ok {
+		return formatted, nil
+	}
+	return s, nil
+}
+
+// isDateMath returns whether s is a date math expression relative to now.
+func isDateMath(s string) bool {
+	return strings.HasPrefix(s, "now")
+}
+
+// evalDateMath returns the time of a date math expression relative to now.
+// Rounding (/d) is always down, in timeZone, so --time-start=now-1d/d and
+// --time-end=now/d are all of yesterday.
+func evalDateMath(s string, now time.Time) (time.Time, error) {
+	t := now.In(timeZone)
+	rest := strings.TrimPrefix(s, "now")
+	for rest != "" {
+		op := rest[0]
+		rest = rest[1:]
+		n := 1
+		if op == '+' || op == '-' {
+			i := 0
+			for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+				i++
+			}
+			if i > 0 {
+				n, _ = strconv.Atoi(rest[:i])
+			}
+			rest = rest[i:]
+			if op == '-' {
+				n = -n
+			}
+		} else if op != '/' {
+			return t, fmt.Errorf("invalid date math %s: unexpected %q", s, op)
+		}
+		if rest == "" {
+			return t, fmt.Errorf("invalid date math %s: missing unit", s)
+		}
+		unit := rest[0]
+		rest = rest[1:]
+		var err error
+		if op == '/' {
+			t, err = roundTime(t, unit)
+		} else {
+			t, err = addTime(t, n, unit)
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid date math %s: %s", s, err.Error())
+		}
+	}
+	return t, nil
+}
+
+// localTime converts a time in timeZone, in the default format or
+// ISO 8601 without an offset, to UTC. Other values are returned as is.
+func localTime(s string) string {
+	if s == "" || timeZone == time.UTC {
+		return s
+	}
+	var layouts []string
+	switch timeFormat {
+	case defaultTimeFormat:
+		layouts = []string{timeLayout}
+	case "strict_date_optional_time", "date_optional_time":
+		layouts = []string{"2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, timeZone); err == nil {
+			formatted, _ := formatTime(t)
+			return formatted
+		}
+	}
+	return s
+}
+
+// addTime adds n of a date math unit (y, M, w, d, h, H, m or s) to t.
+func addTime(t time.Time, n int, unit byte) (time.Time, error) {
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	}
+	return t, fmt.Errorf("unknown unit %q", unit)
+}
+
+// roundTime rounds t down to the start of a date math unit. Weeks start on
+// Monday.
+func roundTime(t time.Time, unit byte) (time.Time, error) {
+	y, mo, d := t.Date()
+	switch unit {
+	case 'y':
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	case 'M':
+		return time.Date(y, mo, 1, 0, 0, 0, 0, t.Location()), nil
+	case 'w':
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, mo, d-offset, 0, 0, 0, 0, t.Location()), nil
+	case 'd':
+		return time.Date(y, mo, d, 0, 0, 0, 0, t.Location()), nil
+	case 'h', 'H':
+		return time.Date(y, mo, d, t.Hour(), 0, 0, 0, t.Location()), nil
+	case 'm':
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case 's':
+		return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, t.Location()), nil
+	}
+	return t, fmt.Errorf("unknown unit %q", unit)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/schollz/progressbar/v3"
+)
+
+var (
+	// Delete documents by _id
+	deleteCmd     = app.Command("delete", "Delete documents from an index by _id, listed in a file or taken from an export file, e.g. to remove a batch that was imported")
+	deleteURL     = deleteCmd.Flag("url", "Elasticsearch host (http://host:port/)").Required().URL()
+	deleteIndex   = deleteCmd.Flag("index", "Index to delete the documents from (default: the _index of each document of --source-file)").String()
+	deleteIDsFile = deleteCmd.Flag("ids-file", "File with the _id of each document to delete, one per line").ExistingFile()
+	deleteSrcFile = deleteCmd.Flag("source-file", "Export file of the documents to delete, by their _index, _id and routing").ExistingFile()
+	deleteBatch   = deleteCmd.Flag("batch-size", "Number of documents to delete with each bulk request").Default("1000").Int()
+	deleteRefresh = deleteCmd.Flag("refresh", "Refresh the indices after deleting, so that searches no longer find the documents").Bool()
+)
+
+// deleteCounts are the outcomes of the deletes.
+type deleteCounts struct {
+	deleted, notFound, failed, skipped int64
+	indices                            map[string]bool
+}
+
+func doDelete() error {
+	if (*deleteIDsFile == "") == (*deleteSrcFile == "") {
+		return fmt.Errorf("exactly one of --ids-file or --source-file is required")
+	}
+	if *deleteIDsFile != "" && *deleteIndex == "" {
+		return fmt.Errorf("--index is required with --ids-file")
+	}
+	client, info, err := newElasticClient((*deleteURL).String(), destSide)
+	if err != nil {
+		return err
+	}
+	dstCluster = info
+	src := *deleteIDsFile + *deleteSrcFile
+	target := *deleteIndex
+	if target == "" {
+		target = "their indices"
+	}
+	summary.Source = src
+	summary.Destination = fmt.Sprintf("%s/%s", strings.TrimSuffix((*deleteURL).String(), "/"), *deleteIndex)
+
+	counts := &deleteCounts{indices: map[string]bool{}}
+	if *dryRun {
+		err = readDeleteActions(counts, func(a bulkAction) error {
+			counts.deleted++
+			counts.indices[a.Index] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		logger.Printf("dry run: would delete %d documents from %s, skipping %d without an _id\n", counts.deleted, strings.Join(sortedKeys(counts.indices), ", "), counts.skipped)
+		return nil
+	}
+	if err = confirm(fmt.Sprintf("Delete the documents of %s from %s on %s?", src, target, redact((*deleteURL).String()))); err != nil {
+		return err
+	}
+
+	logger.Printf("deleting the documents of %s from %s\n", src, target)
+	bar = progressbar.NewOptions64(-1, progressbar.OptionSetRenderBlankState(true), progressbar.OptionSetWriter(os.Stderr))
+	b := newBulkBatch()
+	flush := func() error {
+		if b.len() == 0 {
+			return nil
+		}
+		failed, err := sendBulk(rootCtx, client, b)
+		if err != nil {
+			return deadlineError(err)
+		}
+		counts.add(b.len(), failed)
+		bar.Add(b.len())
+		b.release()
+		b = newBulkBatch()
+		return nil
+	}
+	err = readDeleteActions(counts, func(a bulkAction) error {
+		line, err := json.Marshal(map[string]bulkAction{"delete": a})
+		if err != nil {
+			return err
+		}
+		b.body.Write(line)
+		b.body.WriteByte('\n')
+		b.ends = append(b.ends, b.body.Len())
+		counts.indices[a.Index] = true
+		if b.len() >= *deleteBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+	bar.Finish()
+	if *deleteRefresh && len(counts.indices) > 0 {
+		if _, err = client.Refresh(sortedKeys(counts.indices)...).Do(rootCtx); err != nil {
+			return fmt.Errorf("error refreshing the indices: %s", err.Error())
+		}
+	}
+	logger.Printf("\ndeleted %d documents, %d were not found\n", counts.deleted, counts.notFound)
+	if counts.skipped > 0 {
+		logger.Printf("skipped %d documents of %s without an _id\n", counts.skipped, src)
+	}
+	if counts.failed > 0 {
+		return fmt.Errorf("%d documents could not be deleted", counts.failed)
+	}
+	return nil
+}
+
+// add counts the outcome of a bulk request of n deletes. Documents that are
+// not found are reported, but are not a failure.
+func (c *deleteCounts) add(n int, failed []*elastic.BulkResponseItem) {
+	var reported bool
+	for _, item := range failed {
+		if item.Status == http.StatusNotFound && item.Error == nil {
+			c.notFound++
+			continue
+		}
+		c.failed++
+		if !reported && item.Error != nil {
+			bar.Clear()
+			logger.Printf("error deleting document %s from index %s: %s\n", item.Id, item.Index, item.Error.Reason)
+			reported = true
+		}
+	}
+	deleted := int64(n - len(failed))
+	c.deleted += deleted
+	atomic.AddInt64(&docCount, deleted)
+}
+
+// readDeleteActions calls fn with the delete action of each document of
+// --ids-file or --source-file. Documents of an export without an _id, which
+// were given one by the cluster on import, are counted as skipped.
+func readDeleteActions(counts *deleteCounts, fn func(bulkAction) error) error {
+	if *deleteIDsFile != "" {
+		ids, err := readIDs(*deleteIDsFile)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			a := bulkAction{Index: *deleteIndex, Type: bulkType(elastic.SearchHit{}, dstCluster), ID: id}
+			if err = fn(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := os.Open(*deleteSrcFile)
+	if err != nil {
+		return err
+	}
+	in, err := readExportFile(f)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReaderSize(in, 16384)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			hit, e := parseHit(line, "")
+			if e != nil {
+				return fmt.Errorf("line %d of %s: %s", n, *deleteSrcFile, e.Error())
+			}
+			a := bulkAction{Index: hit.Index, Type: bulkType(hit, dstCluster), ID: hit.Id, Routing: hit.Routing}
+			if *deleteIndex != "" {
+				a.Index = *deleteIndex
+			}
+			if a.Index == "" {
+				return fmt.Errorf("line %d of %s has no _index, use --index", n, *deleteSrcFile)
+			}
+			if a.ID == "" {
+				counts.skipped++
+			} else if e = fn(a); e != nil {
+				return e
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// sortedKeys returns the keys of a set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// Compare two indices
+	diffCmd      = app.Command("diff", "Compare two indices, possibly on different clusters: document counts, mappings and optionally the documents")
+	diffSrcURL   = diffCmd.Flag("source-url", "Elasticsearch host of the first index (http://host:port/)").Required().URL()
+	diffSrcIndex = diffCmd.Flag("source-index", "First index to compare").Required().String()
+	diffDstURL   = diffCmd.Flag("dest-url", "Elasticsearch host of the second index (default: --source-url)").URL()
+	diffDstIndex = diffCmd.Flag("dest-index", "Second index to compare (default: --source-index)").String()
+	diffContent  = diffCmd.Flag("content", "Also compare the documents, by _id and a hash of their _source, to list the missing, extra and changed ones").Bool()
+	diffShow     = diffCmd.Flag("show", "Number of _ids listed for each kind of document difference").Default("10").Int()
+)
+
+func doDiff() error {
+	dstURL := (*diffSrcURL).String()
+	if *diffDstURL != nil {
+		dstURL = (*diffDstURL).String()
+	}
+	dstIndex := *diffDstIndex
+	if dstIndex == "" {
+		dstIndex = *diffSrcIndex
+	}
+	if dstURL == (*diffSrcURL).String() && dstIndex == *diffSrcIndex {
+		return fmt.Errorf("--dest-url or --dest-index is required to compare an index with another")
+	}
+	src, info, err := newElasticClient((*diffSrcURL).String(), sourceSide)
+	if err != nil {
+		return err
+	}
+	srcCluster = info
+	dst, info, err := newElasticClient(dstURL, destSide)
+	if err != nil {
+		return err
+	}
+	dstCluster = info
+	logger.Printf("comparing index %s with index %s\n", *diffSrcIndex, dstIndex)
+
+	differences := 0
+	srcCount, err := src.Count(*diffSrcIndex).Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error counting documents in index %s: %s", *diffSrcIndex, err.Error())
+	}
+	dstCount, err := dst.Count(dstIndex).Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error counting documents in index %s: %s", dstIndex, err.Error())
+	}
+	if srcCount != dstCount {
+		logger.Printf("documents: %d in %s, %d in %s\n", srcCount, *diffSrcIndex, dstCount, dstIndex)
+		differences++
+	} else {
+		logger.Printf("documents: %d in both\n", srcCount)
+	}
+
+	diffs, err := diffMappings(src, *diffSrcIndex, dst, dstIndex)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		logger.Printf("mapping: %s\n", d)
+	}
+	if len(diffs) == 0 {
+		logger.Printf("mappings: the same\n")
+	}
+	differences += len(diffs)
+
+	if *diffContent {
+		n, err := diffDocuments(src, *diffSrcIndex, dst, dstIndex)
+		if err != nil {
+			return err
+		}
+		differences += n
+	}
+	if differences > 0 {
+		return fmt.Errorf("the indices differ")
+	}
+	logger.Printf("the indices are the same\n")
+	return nil
+}
+
+// diffMappings returns the fields that are only in one of the mappings of
+// two indices, or have a different type.
+func diffMappings(src *elastic.Client, srcIndex string, dst *elastic.Client, dstIndex string) ([]string, error) {
+	types := func(client *elastic.Client, index string) (map[string]string, error) {
+		m, err := readMappingsFromElastic(client, index)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the mappings of index %s: %s", index, err.Error())
+		}
+		// The fields of all the indices of a pattern or alias.
+		fields := map[string]string{}
+		for _, im := range m {
+			fieldTypes(im.(map[string]interface{})["mappings"], "", fields)
+		}
+		return fields, nil
+	}
+	have, err := types(src, srcIndex)
+	if err != nil {
+		return nil, err
+	}
+	other, err := types(dst, dstIndex)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	for field, t := range have {
+		switch o, ok := other[field]; {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("field %s is only in %s", field, srcIndex))
+		case o != t:
+			diffs = append(diffs, fmt.Sprintf("field %s is a %s in %s and a %s in %s", field, t, srcIndex, o, dstIndex))
+		}
+	}
+	for field := range other {
+		if _, ok := have[field]; !ok {
+			diffs = append(diffs, fmt.Sprintf("field %s is only in %s", field, dstIndex))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// diffDocuments compares the documents of two indices by _id and a hash of
+// their _source, and reports the missing, extra and changed ones. It
+// returns the number of documents that differ. The hashes of the first
+// index are kept in memory.
+func diffDocuments(src *elastic.Client, srcIndex string, dst *elastic.Client, dstIndex string) (int, error) {
+	hashes := map[string]uint64{}
+	err := hashDocuments(src, srcIndex, func(id string, h uint64) {
+		hashes[id] = h
+	})
+	if err != nil {
+		return 0, err
+	}
+	var extra, changed []string
+	err = hashDocuments(dst, dstIndex, func(id string, h uint64) {
+		have, ok := hashes[id]
+		switch {
+		case !ok:
+			extra = append(extra, id)
+		case have != h:
+			changed = append(changed, id)
+		}
+		delete(hashes, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	missing := make([]string, 0, len(hashes))
+	for id := range hashes {
+		missing = append(missing, id)
+	}
+	report := func(what, index string, ids []string) {
+		if len(ids) == 0 {
+			return
+		}
+		sort.Strings(ids)
+		shown := ids
+		if len(shown) > *diffShow {
+			shown = shown[:*diffShow]
+		}
+		list := strings.Join(shown, ", ")
+		if len(shown) < len(ids) {
+			list += ", ..."
+		}
+		logger.Printf("%d documents %s %s: %s\n", len(ids), what, index, list)
+	}
+	report("missing from", dstIndex, missing)
+	report("only in", dstIndex, extra)
+	report("changed in", dstIndex, changed)
+	if len(missing)+len(extra)+len(changed) == 0 {
+		logger.Printf("documents: the same\n")
+	}
+	return len(missing) + len(extra) + len(changed), nil
+}
+
+// hashDocuments pages through an index and calls fn with the _id of each
+// document and a hash of its _source. The source is hashed in a canonical
+// form, so the order of the keys and the spacing do not matter.
+func hashDocuments(client *elastic.Client, index string, fn func(id string, h uint64)) error {
+	hits := make(chan interface{})
+	g, ctx := errgroup.WithContext(rootCtx)
+	g.Go(func() error {
+		defer close(hits)
+		return scrollIndex(ctx, client, index, nil, "", hits)
+	})
+	g.Go(func() error {
+		for h := range hits {
+			line := h.(rawHit)
+			var doc interface{}
+			if err := decodeJSON([]byte(gjson.GetBytes(line, "_source").Raw), &doc); err != nil {
+				return fmt.Errorf("error parsing document in index %s: %s", index, err.Error())
+			}
+			canonical, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			hash := fnv.New64a()
+			hash.Write(canonical)
+			fn(gjson.GetBytes(line, "_id").String(), hash.Sum64())
+		}
+		return nil
+	})
+	return g.Wait()
+}
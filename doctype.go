@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/sjson"
+)
+
+// Mapping types of documents exported from Elasticsearch 6.x.
+var (
+	docTypeFlag  = app.Flag("doc-type", "What to do with the mapping type (_type) of documents exported from Elasticsearch 6.x: preserve it (6.x destinations only), fold it into a field, or drop it (auto preserves it on 6.x destinations and drops it on later ones)").Default("auto").Enum("auto", "preserve", "field", "drop")
+	docTypeField = app.Flag("doc-type-field", "Field to keep the mapping type of each document in with --doc-type=field").Default("doc_type").String()
+)
+
+// docTypeMode returns what to do with the mapping type of the documents
+// imported to the cluster: preserve, field or drop.
+func (c *clusterInfo) docTypeMode() string {
+	if *docTypeFlag != "auto" {
+		return *docTypeFlag
+	}
+	if c.typedMappings() {
+		return "preserve"
+	}
+	return "drop"
+}
+
+// checkDocType fails if --doc-type cannot be used with the cluster.
+func checkDocType(c *clusterInfo) error {
+	if c.docTypeMode() == "preserve" && !c.typedMappings() {
+		return fmt.Errorf("--doc-type=preserve needs an Elasticsearch 6.x destination, %s %s has no mapping types - use --doc-type=field or drop", c.Distribution, c.Version)
+	}
+	return nil
+}
+
+// applyDocType folds the mapping type of a hit into a field, or drops it,
+// as set by --doc-type for the destination cluster.
+func applyDocType(hit elastic.SearchHit, c *clusterInfo) (elastic.SearchHit, error) {
+	mode := c.docTypeMode()
+	if mode == "field" && hit.Type != "" {
+		src, err := sjson.SetBytes(hit.Source, *docTypeField, hit.Type)
+		if err != nil {
+			return hit, fmt.Errorf("error setting %s: %s", *docTypeField, err.Error())
+		}
+		hit.Source = src
+	}
+	if mode != "preserve" {
+		hit.Type = ""
+	}
+	return hit, nil
+}
+
+// bulkType returns the mapping type to bulk index a hit with, or "" if the
+// cluster has no mapping types.
+func bulkType(hit elastic.SearchHit, c *clusterInfo) string {
+	if !c.typedMappings() {
+		return ""
+	}
+	if hit.Type != "" {
+		return hit.Type
+	}
+	return "_doc"
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	dryRun = app.Flag("dry-run", "Connect, validate the flags, resolve the indices, count the documents and check the destination, and print what would be done, without moving any data (export, import, sync, delete, reindex, snapshot and restore)").Bool()
+)
+
+// dryRunCommands are the commands that honor --dry-run.
+var dryRunCommands = map[string]bool{
+	exportCmd.FullCommand():   true,
+	importCmd.FullCommand():   true,
+	syncCmd.FullCommand():     true,
+	deleteCmd.FullCommand():   true,
+	reindexCmd.FullCommand():  true,
+	snapshotCmd.FullCommand(): true,
+	restoreCmd.FullCommand():  true,
+}
+
+// checkDryRun fails if --dry-run is given to a command that does not honor
+// it, rather than letting the command do the work anyway.
+func checkDryRun(command string) error {
+	if *dryRun && !dryRunCommands[command] {
+		return fmt.Errorf("--dry-run is not supported by the %s command", command)
+	}
+	return nil
+}
+
+// dryRunExport prints what an export would do.
+func dryRunExport(client *elastic.Client, opts exportOptions) error {
+	mappings, err := readMappingsFromElastic(client, opts.index)
+	if err != nil {
+		return fmt.Errorf("error getting the mappings of index %s: %s", opts.index, err.Error())
+	}
+	total := opts.total
+	if opts.maxDocs > 0 && opts.maxDocs < total {
+		total = opts.maxDocs
+	}
+	logger.Printf("dry run: would export %d documents from %s\n", total, strings.Replace(opts.index, ",", ", ", -1))
+	if opts.followInterval > 0 {
+		logger.Printf("dry run: then follow %s for newer documents every %s\n", opts.followField, opts.followInterval)
+	}
+	switch {
+	case opts.dstFile == "-":
+		logger.Printf("dry run: would write the documents to stdout\n")
+	case opts.dstFile != "":
+		logger.Printf("dry run: would write the documents to %s and the mappings to %s\n", opts.dstFile, mappingsFileName(opts.dstFile))
+	default:
+		u, err := url.Parse(opts.dst)
+		if err != nil {
+			return fmt.Errorf("invalid destination %s: %s", opts.dst, err.Error())
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			logger.Printf("dry run: would write the documents to %s\n", opts.dst)
+			return nil
+		}
+		dstIndex := strings.Trim(u.Path, "/")
+		u.Path = ""
+		dst, info, err := newElasticClient(u.String(), destSide)
+		if err != nil {
+			return err
+		}
+		dstCluster = info
+		if err = checkDocType(info); err != nil {
+			return err
+		}
+		for index, m := range mappings {
+			if dstIndex != "" {
+				index = dstIndex
+			}
+			if err = dryRunIndex(dst, index, m.(map[string]interface{})["mappings"], true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dryRunImport prints what an import would do. The source file is read to
+// count the documents, but nothing is written.
+func dryRunImport(client *elastic.Client, src, dstIndex string, appending bool) error {
+	if *importSrcFile == nil {
+		target := dstIndex
+		if target == "" {
+			target = "the indices named by the documents"
+		}
+		logger.Printf("dry run: would import the documents from %s into %s\n", src, target)
+		return nil
+	}
+	var mappings []byte
+	var err error
+	switch {
+	case *importNoMapping:
+		logger.Printf("dry run: would leave the creation of the indices to the templates and dynamic mapping of the cluster\n")
+	case *importMappings == "infer":
+		if isFIFO(src) {
+			// Inferring would consume the stream.
+			logger.Printf("dry run: would create the index with mappings inferred from the first documents\n")
+			mappings = dynamicMappings(dstIndex)
+			break
+		}
+		f, err := openExportFile(src)
+		if err != nil {
+			return err
+		}
+		mappings, _, err = inferMappings(f, dstIndex, *importInferSample)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		logger.Printf("dry run: would create the index with the inferred mappings %s\n", gjson.GetBytes(mappings, "*.mappings").Raw)
+	case *importMappings == "dynamic":
+		logger.Printf("dry run: would create the index without mappings, for dynamic mapping\n")
+		mappings = dynamicMappings(dstIndex)
+	default:
+		if mappings, err = readImportMappings(src, dstIndex); err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(mappings) {
+			return fmt.Errorf("invalid mappings file %s", importMappingsFile(src))
+		}
+	}
+	if mappings, err = renameMappingFields(mappings, *importRenames); err != nil {
+		return err
+	}
+	if settings, err := readImportSettings(src); err != nil {
+		return err
+	} else if settings.override != nil {
+		b, _ := json.Marshal(settings.override)
+		logger.Printf("dry run: would create the indices with the settings %s\n", b)
+	}
+	if isFIFO(src) {
+		// Counting would consume the stream.
+		logger.Printf("dry run: would import the documents of named pipe %s\n", src)
+	} else {
+		docs, err := countLines(src)
+		if err != nil {
+			return err
+		}
+		logger.Printf("dry run: would import %d documents from %s\n", docs, src)
+	}
+	if *importTimeField != "" || len(transforms) > 0 {
+		logger.Printf("dry run: some of them may be filtered out or changed by the transforms\n")
+	}
+	if ilm, err := readILMFromFile(src); err != nil {
+		return err
+	} else if ilm != nil && *importILM == "recreate" && dstCluster.supportsILM() {
+		logger.Printf("dry run: would recreate the ILM policies saved with the export\n")
+	}
+	if *importPipelines {
+		if pipelines, err := readPipelinesFromFile(src); err != nil {
+			return err
+		} else if pipelines != nil {
+			logger.Printf("dry run: would recreate the ingest pipelines saved with the export\n")
+		}
+	}
+
+	if *importIdxField != "" || *importSplit != "" {
+		logger.Printf("dry run: would route the documents to indices by %s, created as they are seen\n", routeDescription())
+	}
+	var failed error
+	gjson.ParseBytes(mappings).ForEach(func(k, v gjson.Result) bool {
+		index := dstIndex
+		if index == "" {
+			if router == nil || *importIdxField != "" || *importSplit != "" {
+				return false
+			}
+			// Each index of the export is imported to its renamed original.
+			name, _ := router.name(elastic.SearchHit{Index: k.String()})
+			index = strings.ToLower(router.prefix + name + router.suffix)
+		}
+		failed = dryRunIndex(client, index, v.Get("mappings").Value(), appending)
+		// A single destination index gets the mappings of the first index.
+		return failed == nil && dstIndex == ""
+	})
+	return failed
+}
+
+// routeDescription describes how documents are routed to indices on import.
+func routeDescription() string {
+	if *importIdxField != "" {
+		return "the field " + *importIdxField
+	}
+	return "time (" + *importSplit + ")"
+}
+
+// dryRunReindex prints what a reindex would do: the documents of each
+// source index, and the destination indices it would create or add to.
+// The mappings of the indices to create are checked against the cluster.
+func dryRunReindex(src, dst *elastic.Client, names []string, targets map[string]string, q elastic.Query, remote *url.URL) error {
+	ctx := context.Background()
+	for _, index := range names {
+		counter := src.Count(index)
+		if q != nil {
+			counter.Query(q)
+		}
+		n, err := counter.Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error counting documents in index %s: %s", index, err.Error())
+		}
+		logger.Printf("dry run: would reindex %d documents of %s from %s into %s\n", n, index, redact(remote.String()), targets[index])
+	}
+	if *reindexNoCreateIdx {
+		return nil
+	}
+	checked := map[string]bool{}
+	for _, index := range names {
+		target := targets[index]
+		if checked[target] {
+			continue
+		}
+		checked[target] = true
+		exists, err := dst.IndexExists(target).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error checking if index %s exists: %s", target, err.Error())
+		}
+		if exists && !*reindexAppend {
+			return fmt.Errorf("index %s already exists on %s, use --append to copy into it", target, redact((*reindexDstURL).String()))
+		} else if exists {
+			logger.Printf("dry run: would add to existing index %s\n", target)
+			continue
+		}
+		logger.Printf("dry run: would create index %s with the mappings of %s\n", target, index)
+		mappings, err := readMappingsFromElastic(src, index)
+		if err != nil {
+			return fmt.Errorf("error getting the mappings of index %s: %s", index, err.Error())
+		}
+		m, _ := mappings[index].(map[string]interface{})
+		if err = dryRunMappings(target, m["mappings"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dryRunSnapshot prints the snapshot that would be taken, and the indices
+// of the cluster it would have. It fails if the snapshot exists already.
+func dryRunSnapshot(client *elastic.Client, name string) error {
+	exists, err := snapshotRepo.dryRun(client)
+	if err != nil {
+		return err
+	}
+	if exists {
+		snaps, err := listSnapshots(client, *snapshotRepo.name, "_all")
+		if err != nil {
+			return err
+		}
+		for _, snap := range snaps {
+			if snap.Snapshot == name {
+				return fmt.Errorf("snapshot %s already exists in repository %s", name, *snapshotRepo.name)
+			}
+		}
+	}
+	pattern := *snapshotIndices
+	if pattern == "" {
+		pattern = "_all"
+	}
+	res, err := client.IndexGetSettings(pattern).Name("index.hidden").Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting the indices matching %s: %s", pattern, err.Error())
+	}
+	indices := make([]string, 0, len(res))
+	for index := range res {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	logger.Printf("dry run: would take snapshot %s in repository %s of %s\n", name, *snapshotRepo.name, strings.Join(indices, ", "))
+	if *snapshotGlobal {
+		logger.Printf("dry run: with the cluster state\n")
+	}
+	return nil
+}
+
+// dryRunRestore prints the indices of a snapshot that would be restored,
+// under their new name if renamed, with a warning for those that exist.
+func dryRunRestore(client *elastic.Client, snap *snapshotInfo, rename *regexp.Regexp) error {
+	logger.Printf("dry run: would restore snapshot %s of repository %s\n", snap.Snapshot, *restoreRepo.name)
+	names := append([]string(nil), snap.Indices...)
+	sort.Strings(names)
+	for _, index := range names {
+		if *restoreIndices != "" && !matchesAny(index, strings.Split(*restoreIndices, ",")) {
+			continue
+		}
+		target := index
+		if rename != nil {
+			target = rename.ReplaceAllString(index, *restoreRenameTo)
+		}
+		if target != index {
+			logger.Printf("dry run: would restore index %s as %s\n", index, target)
+		} else {
+			logger.Printf("dry run: would restore index %s\n", index)
+		}
+		exists, err := client.IndexExists(target).Do(context.Background())
+		if err != nil {
+			return fmt.Errorf("error checking if index %s exists: %s", target, err.Error())
+		}
+		if exists {
+			logger.Printf("dry run: warning: index %s exists, the restore fails unless it is closed or deleted\n", target)
+		}
+	}
+	if *restoreGlobal {
+		logger.Printf("dry run: would restore the cluster state\n")
+	}
+	return nil
+}
+
+// dryRunIndex prints whether an index would be created, replaced or added
+// to with the mappings, and fails if it exists and cannot be added to. The
+// fields whose type differs from the existing mapping are listed, and the
+// mappings of an index to create are checked against the cluster.
+func dryRunIndex(client *elastic.Client, index string, mappings interface{}, appending bool) error {
+	exists, err := client.IndexExists(index).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error checking if index %s exists: %s", index, err.Error())
+	}
+	switch {
+	case !exists:
+		logger.Printf("dry run: would create index %s\n", index)
+		return dryRunMappings(index, mappings)
+	case *importForce:
+		logger.Printf("dry run: would delete index %s and recreate it\n", index)
+		return dryRunMappings(index, mappings)
+	case !appending:
+		return fmt.Errorf("index %s exists - use --append to add to it, or --force to replace it", index)
+	}
+	logger.Printf("dry run: would add to existing index %s\n", index)
+	existing, err := readMappingsFromElastic(client, index)
+	if err != nil {
+		return fmt.Errorf("error getting the mappings of index %s: %s", index, err.Error())
+	}
+	for _, m := range existing {
+		for _, c := range mappingConflicts(m.(map[string]interface{})["mappings"], mappings) {
+			logger.Printf("dry run: warning: %s\n", c)
+		}
+	}
+	return nil
+}
+
+// dryRunMappings checks the mappings of an index to create against the
+// destination cluster, as they would be when it is created.
+func dryRunMappings(index string, mappings interface{}) error {
+	if _, err := adaptMappings(mappings, dstCluster); err != nil {
+		return fmt.Errorf("error in mappings of index %s: %s", index, err.Error())
+	}
+	return nil
+}
+
+// mappingConflicts returns the fields whose type differs between two
+// mappings, typed or not.
+func mappingConflicts(existing, imported interface{}) []string {
+	have := fieldTypes(existing, "", map[string]string{})
+	var conflicts []string
+	for field, t := range fieldTypes(imported, "", map[string]string{}) {
+		if h, ok := have[field]; ok && h != t {
+			conflicts = append(conflicts, fmt.Sprintf("field %s is a %s in the destination and a %s in the import", field, h, t))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// fieldTypes adds the type of each field of a mapping, by dot path, to
+// types. Objects without a type are object.
+func fieldTypes(m interface{}, prefix string, types map[string]string) map[string]string {
+	mapping, ok := m.(map[string]interface{})
+	if !ok {
+		return types
+	}
+	props, ok := mapping["properties"].(map[string]interface{})
+	if !ok && len(mapping) == 1 {
+		// A mapping wrapped in its type.
+		for _, typed := range mapping {
+			return fieldTypes(typed, prefix, types)
+		}
+	}
+	for name, p := range props {
+		field, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t, _ := field["type"].(string)
+		if t == "" {
+			t = "object"
+		}
+		types[prefix+name] = t
+		fieldTypes(field, prefix+name+".", types)
+	}
+	return types
+}
+
+// mappingsFileName returns the name of the mappings file of an export file.
+func mappingsFileName(file string) string {
+	return strings.Replace(trimCompression(strings.Replace(file, ".gz", "", 1)), ".json", "", 1) + "-mapping.json"
+}
+
+// countLines returns the number of non-empty lines of a file, gunzipped
+// if it ends in .gz or .zst.
+func countLines(file string) (int64, error) {
+	f, err := openExportFile(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var n int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) > 0 {
+			n++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading file %s: %s", file, err.Error())
+	}
+	return n, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// searchPage is a search response with a page of n hits.
+func searchPage(n int) []byte {
+	hits := make([]string, n)
+	for i := range hits {
+		hits[i] = fmt.Sprintf(`{"_index":"logs","_type":"_doc","_id":"%d","_score":1,"_source":{"message":"hello world this is a log line","user":{"name":"x","id":12},"tags":["a","b","c"],"@timestamp":"2024-01-01T00:00:00Z","n":12345}}`, i)
+	}
+	return []byte(`{"hits":{"hits":[` + strings.Join(hits, ",") + `]}}`)
+}
+
+// BenchmarkExportPage measures writing the hits of a page of a search
+// response as the lines of an export file: searchhit decodes the response
+// into search hits and encodes each of them again, raw sends slices of the
+// response through the channel.
+func BenchmarkExportPage(b *testing.B) {
+	page := searchPage(100)
+	b.Run("searchhit", func(b *testing.B) {
+		w := bufio.NewWriter(io.Discard)
+		hits := make(chan interface{}, 200)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var res elastic.SearchResult
+			if err := json.Unmarshal(page, &res); err != nil {
+				b.Fatal(err)
+			}
+			for _, h := range res.Hits.Hits {
+				hits <- *h
+			}
+			for range res.Hits.Hits {
+				line, err := json.Marshal((<-hits).(elastic.SearchHit))
+				if err != nil {
+					b.Fatal(err)
+				}
+				w.Write(line)
+				w.WriteByte('\n')
+			}
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		w := bufio.NewWriter(io.Discard)
+		hits := make(chan interface{}, 200)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			n, _, err := sendRawHits(context.Background(), page, hits)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for j := 0; j < n; j++ {
+				w.Write((<-hits).(rawHit))
+				w.WriteByte('\n')
+			}
+		}
+	})
+}
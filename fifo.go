@@ -0,0 +1,19 @@
+package main
+
+import "os"
+
+// isFIFO returns whether file is a named pipe, which is read or written as
+// a stream: it has no size, and is neither created nor truncated.
+func isFIFO(file string) bool {
+	stat, err := os.Stat(file)
+	return err == nil && stat.Mode()&os.ModeNamedPipe != 0
+}
+
+// createFile creates a file to write to, or opens a named pipe for writing.
+// Opening a named pipe waits for a reader.
+func createFile(file string) (*os.File, error) {
+	if isFIFO(file) {
+		return os.OpenFile(file, os.O_WRONLY, 0)
+	}
+	return os.Create(file)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+var (
+	includeHidden = app.Flag("include-hidden", "Include hidden and system indices (with a '.' prefix) in the matches of an index pattern such as '*'").Bool()
+)
+
+// supportsHidden returns whether the cluster has hidden indices, which
+// wildcards only match with expand_wildcards=hidden.
+func (c *clusterInfo) supportsHidden() bool {
+	return c != nil && (c.Distribution != "elasticsearch" || c.Major > 7 || c.Major == 7 && c.Minor >= 7)
+}
+
+// resolveIndices returns the comma separated concrete indices of an index
+// pattern, and the query limiting them to the filters of the aliases in it
+// (nil if there are none). Unless --include-hidden is set, wildcards do
+// not match hidden indices or system indices with a '.' prefix, so '*'
+// does not export .security or .kibana; a pattern starting with '.' still
+// matches them. Indices named without a wildcard, directly or through an
+// alias, are always kept.
+func resolveIndices(ctx context.Context, client *elastic.Client, pattern string) (string, elastic.Query, error) {
+	expand := "open"
+	if *includeHidden && srcCluster.supportsHidden() {
+		expand = "open,hidden"
+	}
+	// The alias filter of each index, nil if it is exported unfiltered.
+	filters := map[string]json.RawMessage{}
+	add := func(index string, filter json.RawMessage) {
+		if f, seen := filters[index]; !seen || f != nil {
+			filters[index] = filter
+		}
+	}
+	for _, part := range strings.Split(pattern, ",") {
+		if !strings.ContainsAny(part, "*?") {
+			aliased, err := aliasIndices(ctx, client, part)
+			if err != nil {
+				return "", nil, err
+			}
+			if aliased == nil {
+				add(part, nil)
+			}
+			for index, filter := range aliased {
+				if *debug {
+					logger.Printf("alias %s points to index %s\n", part, index)
+				}
+				add(index, filter)
+			}
+			continue
+		}
+		res, err := client.IndexGetSettings(part).Name("index.hidden").ExpandWildcards(expand).FlatSettings(true).Do(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("error getting the indices matching %s: %s", part, err.Error())
+		}
+		for index, s := range res {
+			system := strings.HasPrefix(index, ".") && !strings.HasPrefix(part, ".")
+			hidden := fmt.Sprint(s.Settings["index.hidden"]) == "true"
+			if *includeHidden || !system && !hidden {
+				add(index, nil)
+			}
+		}
+	}
+	if len(filters) == 0 {
+		return "", nil, fmt.Errorf("no indices match %s (use --include-hidden for hidden and system indices)", pattern)
+	}
+	indices := make([]string, 0, len(filters))
+	for index := range filters {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	if *debug && len(indices) > 1 {
+		logger.Printf("exporting indices %s\n", strings.Join(indices, ", "))
+	}
+	return strings.Join(indices, ","), aliasFilter(filters), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	exportIDsFile = exportCmd.Flag("ids-file", "File with the _id of each document to export, one per line, to export exactly those documents").ExistingFile()
+)
+
+// idsChunkSize is the number of ids searched for at a time, well below
+// the default index.max_terms_count of 65536.
+const idsChunkSize = 1000
+
+// readIDs returns the ids listed in a file, one per line, skipping blank
+// lines and repeated ids.
+func readIDs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening ids file %s: %s", path, err.Error())
+	}
+	defer f.Close()
+	var ids []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading ids file %s: %s", path, err.Error())
+	}
+	return ids, nil
+}
+
+// readDataByIDs searches the index for the documents with the ids, in
+// chunks of idsChunkSize with an ids query, and sends each hit to the
+// channel. The documents must also match q, if set.
+func readDataByIDs(ctx context.Context, srcIndex string, q elastic.Query, ids []string, preference string, g *errgroup.Group, client *elastic.Client, hits chan interface{}) {
+	g.Go(func() error {
+		defer close(hits)
+		for start := 0; start < len(ids); start += idsChunkSize {
+			end := start + idsChunkSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			var chunk elastic.Query = elastic.NewIdsQuery().Ids(ids[start:end]...)
+			if q != nil {
+				chunk = elastic.NewBoolQuery().Filter(chunk, q)
+			}
+			err := scrollIndex(ctx, client, srcIndex, chunk, preference, hits)
+			if err == errMaxDocs {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// ilmExport is the ILM policies attached to the exported indices, saved
+// next to the export as <name>-ilm.json.
+type ilmExport struct {
+	// Indices are the policy name of each index.
+	Indices map[string]string `json:"indices"`
+	// Policies are the policies by name.
+	Policies map[string]interface{} `json:"policies"`
+}
+
+// companionFile returns the path of a file saved next to an export, e.g.
+// output-ilm.json for output.json.gz.
+func companionFile(file, suffix string) string {
+	f := strings.TrimSuffix(trimCompression(file), ".json")
+	return f + "-" + suffix + ".json"
+}
+
+// readILMFromElastic returns the ILM policies attached to the indices, or
+// nil if there are none.
+func readILMFromElastic(client *elastic.Client, index string) (*ilmExport, error) {
+	ctx := context.Background()
+	res, err := client.IndexGetSettings(index).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting settings of index %s: %s", index, err.Error())
+	}
+	e := &ilmExport{Indices: map[string]string{}, Policies: map[string]interface{}{}}
+	for name, s := range res {
+		policy, ok := s.Settings["index.lifecycle.name"].(string)
+		if !ok || policy == "" {
+			continue
+		}
+		e.Indices[name] = policy
+		if _, ok := e.Policies[policy]; ok {
+			continue
+		}
+		p, err := client.XPackIlmGetLifecycle().Policy(policy).Do(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error getting ILM policy %s: %s", policy, err.Error())
+		}
+		if r, ok := p[policy]; ok {
+			e.Policies[policy] = r.Policy
+		}
+	}
+	if len(e.Indices) == 0 {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// exportILM saves the ILM policies attached to the indices next to the
+// export, if there are any.
+func exportILM(client *elastic.Client, index, file string) error {
+	if !srcCluster.supportsILM() {
+		return nil
+	}
+	e, err := readILMFromElastic(client, index)
+	if err != nil || e == nil {
+		return err
+	}
+	logger.Printf("saving ILM policies to %s\n", companionFile(file, "ilm"))
+	return writeILMToFile(file, e)
+}
+
+// writeILMToFile saves the ILM policies next to the export.
+func writeILMToFile(file string, e *ilmExport) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(companionFile(file, "ilm"), b, 0644)
+}
+
+// readILMFromFile reads the ILM policies saved next to an export, or nil if
+// there are none.
+func readILMFromFile(file string) (*ilmExport, error) {
+	f := companionFile(file, "ilm")
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e ilmExport
+	if err = json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("error parsing ILM file %s: %s", f, err.Error())
+	}
+	return &e, nil
+}
+
+// writeILMToElastic creates the ILM policies that do not exist yet. Existing
+// policies are left as they are.
+func writeILMToElastic(client *elastic.Client, e *ilmExport) error {
+	ctx := context.Background()
+	for name, policy := range e.Policies {
+		_, err := client.XPackIlmGetLifecycle().Policy(name).Do(ctx)
+		if err == nil {
+			logger.Printf("ILM policy %s already exists, leaving it as is\n", name)
+			continue
+		}
+		if !elastic.IsNotFound(err) {
+			return fmt.Errorf("error getting ILM policy %s: %s", name, err.Error())
+		}
+		body := map[string]interface{}{"policy": policy}
+		if _, err = client.XPackIlmPutLifecycle().Policy(name).BodyJson(body).Do(ctx); err != nil {
+			return fmt.Errorf("error creating ILM policy %s: %s", name, err.Error())
+		}
+	}
+	return nil
+}
+
+// policyFor returns the policy of an exported index, or of the only index
+// if index is not in the export.
+func (e *ilmExport) policyFor(index string) string {
+	if p, ok := e.Indices[index]; ok {
+		return p
+	}
+	if len(e.Indices) == 1 {
+		for _, p := range e.Indices {
+			return p
+		}
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	// List the indices of a cluster
+	indicesCmd     = app.Command("indices", "List the indices of a cluster that an export of a pattern would read, with their number of documents, size and creation date")
+	indicesURL     = indicesCmd.Flag("url", "Elasticsearch host (http://host:port/)").Required().URL()
+	indicesPattern = indicesCmd.Arg("pattern", "Index pattern, alias or comma separated list of indices to list").Default("*").String()
+	indicesSort    = indicesCmd.Flag("sort", "Order of the list").Default("name").Enum("name", "docs", "size", "created")
+)
+
+func doIndices() error {
+	client, info, err := newElasticClient((*indicesURL).String(), sourceSide)
+	if err != nil {
+		return err
+	}
+	srcCluster = info
+	// The same indices as an export, without hidden or system indices.
+	resolved, _, err := resolveIndices(rootCtx, client, *indicesPattern)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, index := range strings.Split(resolved, ",") {
+		keep[index] = true
+	}
+	res, err := client.CatIndices().Index(*indicesPattern).Bytes("b").
+		Columns("index", "health", "status", "pri", "rep", "docs.count", "store.size", "creation.date", "creation.date.string").Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error listing the indices matching %s: %s", *indicesPattern, err.Error())
+	}
+	rows := res[:0]
+	for _, row := range res {
+		if keep[row.Index] {
+			rows = append(rows, row)
+		}
+	}
+	size := func(i int) int64 {
+		n, _ := strconv.ParseInt(rows[i].StoreSize, 10, 64)
+		return n
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		switch *indicesSort {
+		case "docs":
+			return rows[i].DocsCount > rows[j].DocsCount
+		case "size":
+			return size(i) > size(j)
+		case "created":
+			return rows[i].CreationDate < rows[j].CreationDate
+		}
+		return rows[i].Index < rows[j].Index
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tDOCS\tSIZE\tSHARDS\tCREATED\tHEALTH")
+	var docs, bytes int64
+	for i, row := range rows {
+		docs += int64(row.DocsCount)
+		bytes += size(i)
+		health := row.Health
+		if row.Status == "close" {
+			health = "closed"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d/%d\t%s\t%s\n", row.Index, row.DocsCount, formatBytes(size(i)), row.Pri, row.Rep, row.CreationDateString, health)
+	}
+	if len(rows) > 1 {
+		fmt.Fprintf(w, "%d indices\t%d\t%s\n", len(rows), docs, formatBytes(bytes))
+	}
+	return w.Flush()
+}
+
+// formatBytes returns a number of bytes in a readable unit, e.g. 4.6GB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+var (
+	importMappings    = importCmd.Flag("mappings", "Where the mappings of the new index come from: the mappings file (saved with the export, or --mapping-file), inferred from the first documents, or none (dynamic mapping), e.g. to import a file of raw JSON documents").Default("file").Enum("file", "infer", "dynamic")
+	importInferSample = importCmd.Flag("infer-sample", "Number of documents to infer the mappings from with --mappings=infer").Default("1000").Int()
+	importNoMapping   = importCmd.Flag("no-mapping", "Do not read the mappings file or create the indices, leaving them to the index templates and dynamic mapping of the destination cluster").Bool()
+	importMappingFile = importCmd.Flag("mapping-file", "Mappings file to create the index with instead of the one saved with the export, in the same format or with the mappings of a single index ({\"mappings\": ...} or {\"properties\": ...})").ExistingFile()
+)
+
+// inferDateFormats are the formats of the strings mapped as dates, as the
+// date detection of Elasticsearch does.
+var inferDateFormats = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// inferredField is the type of a field seen in the documents, and its
+// fields if it is an object.
+type inferredField struct {
+	kind   string
+	fields map[string]*inferredField
+}
+
+// replayReader reads the lines read to infer the mappings again, then the
+// rest of the file.
+type replayReader struct {
+	io.Reader
+	io.Closer
+}
+
+// inferMappings returns mappings in the format of a mappings file for
+// index, inferred from the first n documents of in, and a reader of all
+// of in. The documents may be exported lines or raw documents.
+func inferMappings(in io.ReadCloser, index string, n int) ([]byte, io.ReadCloser, error) {
+	r := bufio.NewReader(in)
+	var sample bytes.Buffer
+	root := &inferredField{kind: "object", fields: map[string]*inferredField{}}
+	for docs := 0; docs < n; {
+		line, err := r.ReadBytes('\n')
+		sample.Write(line)
+		if doc := bytes.TrimSpace(line); len(doc) > 0 {
+			if src := gjson.GetBytes(doc, "_source"); src.Exists() {
+				doc = []byte(src.Raw)
+			}
+			var v map[string]interface{}
+			if e := json.Unmarshal(doc, &v); e != nil {
+				return nil, nil, fmt.Errorf("invalid document on line %d: %s", docs+1, e.Error())
+			}
+			root.add(v)
+			docs++
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	mappings := map[string]interface{}{index: map[string]interface{}{"mappings": root.mapping()}}
+	b, err := json.Marshal(mappings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, replayReader{Reader: io.MultiReader(&sample, r), Closer: in}, nil
+}
+
+// importMappingsFile returns the mappings file of an import of src: the
+// one of --mapping-file, or the one saved with the export.
+func importMappingsFile(src string) string {
+	if *importMappingFile != "" {
+		return *importMappingFile
+	}
+	return mappingsFileName(src)
+}
+
+// readImportMappings reads the mappings file of an import of src. The
+// mappings of a single index in --mapping-file are those of index.
+func readImportMappings(src, index string) ([]byte, error) {
+	if *importMappingFile == "" {
+		return readMappingsFromFile(src)
+	}
+	b, err := ioutil.ReadFile(*importMappingFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mappings file: %s", err.Error())
+	}
+	m := gjson.ParseBytes(b)
+	if !m.IsObject() {
+		return nil, fmt.Errorf("invalid mappings file %s", *importMappingFile)
+	}
+	if m.Get("properties").Exists() {
+		b = []byte(`{"mappings":` + m.Raw + `}`)
+	} else if !m.Get("mappings").IsObject() {
+		// The format of an export.
+		return b, nil
+	}
+	if index == "" {
+		return nil, fmt.Errorf("--dest-index is required with the mappings of a single index in --mapping-file")
+	}
+	return json.Marshal(map[string]json.RawMessage{index: b})
+}
+
+// dynamicMappings returns empty mappings in the format of a mappings file
+// for index, to leave the fields to dynamic mapping.
+func dynamicMappings(index string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{index: map[string]interface{}{"mappings": map[string]interface{}{}}})
+	return b
+}
+
+// add merges the fields of an object into an inferred object.
+func (f *inferredField) add(v map[string]interface{}) {
+	for name, value := range v {
+		field, ok := f.fields[name]
+		if !ok {
+			field = &inferredField{}
+			f.fields[name] = field
+		}
+		field.addValue(value)
+	}
+}
+
+// addValue merges a value into the inferred type. Integers and floats make
+// a double, and any other mix of types a text.
+func (f *inferredField) addValue(value interface{}) {
+	var kind string
+	switch v := value.(type) {
+	case nil:
+		return
+	case []interface{}:
+		for _, e := range v {
+			f.addValue(e)
+		}
+		return
+	case map[string]interface{}:
+		if f.kind != "" && f.kind != "object" {
+			// A field cannot be both; keep the object.
+			f.kind = ""
+		}
+		if f.kind == "" {
+			f.kind = "object"
+			f.fields = map[string]*inferredField{}
+		}
+		f.add(v)
+		return
+	case bool:
+		kind = "boolean"
+	case float64:
+		kind = "long"
+		if v != float64(int64(v)) {
+			kind = "double"
+		}
+	case string:
+		kind = inferStringType(v)
+	}
+	switch {
+	case f.kind == "" || f.kind == kind || f.kind == "object":
+		if f.kind == "" {
+			f.kind = kind
+		}
+	case (f.kind == "long" || f.kind == "double") && (kind == "long" || kind == "double"):
+		f.kind = "double"
+	default:
+		f.kind = "text"
+	}
+}
+
+// inferStringType returns the type of a string value: a date, an IP
+// address, or text.
+func inferStringType(s string) string {
+	for _, layout := range inferDateFormats {
+		if _, err := time.Parse(layout, s); err == nil {
+			return "date"
+		}
+	}
+	if net.ParseIP(s) != nil {
+		return "ip"
+	}
+	return "text"
+}
+
+// mapping returns the mapping of an inferred field. Text has a keyword
+// subfield, as with dynamic mapping.
+func (f *inferredField) mapping() map[string]interface{} {
+	switch f.kind {
+	case "object":
+		props := map[string]interface{}{}
+		for name, field := range f.fields {
+			if field.kind != "" {
+				props[name] = field.mapping()
+			}
+		}
+		return map[string]interface{}{"properties": props}
+	case "text":
+		return map[string]interface{}{
+			"type":   "text",
+			"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}},
+		}
+	}
+	return map[string]interface{}{"type": f.kind}
+}
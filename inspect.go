@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+var (
+	// Show what is in an export file
+	inspectCmd  = app.Command("inspect", "Show what is in an export file without importing it: the indices and number of documents, the files saved with it, and the first documents")
+	inspectFile = inspectCmd.Flag("file", "Export file to inspect (a file with a '.gz' or '.zst' suffix is decompressed first)").Required().ExistingFile()
+	inspectHead = inspectCmd.Flag("head", "Number of documents to print").Default("10").Int()
+)
+
+func doInspect() error {
+	stat, err := os.Stat(*inspectFile)
+	if err != nil {
+		return err
+	}
+	f, err := openExportFile(*inspectFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Count the documents of each index, and keep the first ones.
+	var head [][]byte
+	var docs, sourceOnly int64
+	counts := map[string]int64{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		docs++
+		if len(head) < *inspectHead {
+			head = append(head, append([]byte(nil), line...))
+		}
+		if !gjson.GetBytes(line, "_source").Exists() {
+			sourceOnly++
+			continue
+		}
+		counts[gjson.GetBytes(line, "_index").String()]++
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("error reading file %s: %s", *inspectFile, err.Error())
+	}
+
+	fmt.Printf("file:      %s (%d bytes, written %s)\n", *inspectFile, stat.Size(), stat.ModTime().Format(time.RFC3339))
+	fmt.Printf("documents: %d\n", docs)
+	if len(counts) > 0 {
+		indices := make([]string, 0, len(counts))
+		for index, n := range counts {
+			if index == "" {
+				index = "(none)"
+			}
+			indices = append(indices, fmt.Sprintf("%s (%d)", index, n))
+		}
+		sort.Strings(indices)
+		fmt.Printf("indices:   %s\n", strings.Join(indices, ", "))
+	}
+	if sourceOnly > 0 {
+		fmt.Printf("format:    %d documents are source only, without _index and _id\n", sourceOnly)
+	}
+	if err = inspectCompanions(*inspectFile); err != nil {
+		return err
+	}
+
+	for i, line := range head {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, line, "", "  "); err != nil {
+			fmt.Printf("\ndocument %d is not valid json: %.100s\n", i+1, line)
+			continue
+		}
+		fmt.Printf("\n%s\n", pretty.String())
+	}
+	return nil
+}
+
+// inspectCompanions prints what is in the mappings, settings, ILM and
+// pipelines files saved next to an export.
+func inspectCompanions(file string) error {
+	if m, err := readMappingsFromFile(file); err == nil {
+		indices := gjson.ParseBytes(m).Map()
+		fields := map[string]string{}
+		for _, im := range indices {
+			fieldTypes(im.Get("mappings").Value(), "", fields)
+		}
+		fmt.Printf("mappings:  %s, %d indices, %d fields\n", mappingsFileName(file), len(indices), len(fields))
+	} else {
+		fmt.Printf("mappings:  none (%s is missing, import needs it)\n", mappingsFileName(file))
+	}
+	settings, err := readSettingsFromFile(file)
+	if err != nil {
+		return err
+	}
+	if settings != nil {
+		fmt.Printf("settings:  %s, %d indices\n", companionFile(file, "settings"), len(settings))
+	}
+	ilm, err := readILMFromFile(file)
+	if err != nil {
+		return err
+	}
+	if ilm != nil {
+		fmt.Printf("ilm:       %s, %d policies\n", companionFile(file, "ilm"), len(ilm.Policies))
+	}
+	pipelines, err := readPipelinesFromFile(file)
+	if err != nil {
+		return err
+	}
+	if pipelines != nil {
+		fmt.Printf("pipelines: %s, %d pipelines\n", companionFile(file, "pipelines"), len(pipelines.Pipelines))
+	}
+	return nil
+}
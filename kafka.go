@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"golang.org/x/sync/errgroup"
+)
+
+// Kafka connection settings, shared by the export and import commands.
+var (
+	kafkaTLS         = app.Flag("kafka-tls", "Use TLS to connect to the Kafka brokers").Bool()
+	kafkaTLSCA       = app.Flag("kafka-tls-ca", "CA certificate file to verify the Kafka brokers with").ExistingFile()
+	kafkaTLSInsecure = app.Flag("kafka-tls-insecure", "Skip verification of the Kafka broker certificates").Bool()
+	kafkaSASL        = app.Flag("kafka-sasl", "SASL mechanism to authenticate to the Kafka brokers with").Enum("plain", "scram-sha-256", "scram-sha-512")
+	kafkaUser        = app.Flag("kafka-user", "SASL username for the Kafka brokers").String()
+	kafkaPassword    = app.Flag("kafka-password", "SASL password for the Kafka brokers").Envar("VANDELAY_KAFKA_PASSWORD").String()
+)
+
+// parseKafkaURL splits a kafka://broker1:9092,broker2:9092/topic URL into
+// the brokers and the topic.
+func parseKafkaURL(s string) (brokers []string, topic string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid kafka url %s: %s", s, err.Error())
+	}
+	topic = strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, "", fmt.Errorf("invalid kafka url %s - use kafka://broker:9092/topic", s)
+	}
+	return strings.Split(u.Host, ","), topic, nil
+}
+
+// kafkaTLSConfig returns the TLS configuration from the flags, or nil if
+// TLS is not enabled.
+func kafkaTLSConfig() (*tls.Config, error) {
+	if !*kafkaTLS {
+		return nil, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: *kafkaTLSInsecure}
+	if *kafkaTLSCA != "" {
+		pem, err := ioutil.ReadFile(*kafkaTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *kafkaTLSCA)
+		}
+	}
+	return c, nil
+}
+
+// kafkaMechanism returns the SASL mechanism from the flags, or nil if SASL
+// is not enabled.
+func kafkaMechanism() (sasl.Mechanism, error) {
+	switch *kafkaSASL {
+	case "plain":
+		return plain.Mechanism{Username: *kafkaUser, Password: *kafkaPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, *kafkaUser, *kafkaPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, *kafkaUser, *kafkaPassword)
+	}
+	return nil, nil
+}
+
+// writeDataToKafka publishes each document sent on channel to a Kafka
+// topic, with the _id as the message key, the _source as the value and the
+// _index as a message header. Messages are written in batches and each
+// batch must be acknowledged by all in-sync replicas before the next one is
+// read from the channel, so a slow cluster slows down the export rather
+// than buffering it in memory.
+func writeDataToKafka(ctx context.Context, g *errgroup.Group, dst string, hits chan interface{}) error {
+	brokers, topic, err := parseKafkaURL(dst)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := kafkaTLSConfig()
+	if err != nil {
+		return fmt.Errorf("error configuring kafka tls: %s", err.Error())
+	}
+	mechanism, err := kafkaMechanism()
+	if err != nil {
+		return fmt.Errorf("error configuring kafka sasl: %s", err.Error())
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    1000,
+		Transport: &kafka.Transport{
+			TLS:  tlsConfig,
+			SASL: mechanism,
+		},
+	}
+
+	g.Go(func() error {
+		defer w.Close()
+		batch := make([]kafka.Message, 0, w.BatchSize)
+		for h := range hits {
+			hit, err := toHit(h)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, kafka.Message{
+				Key:     []byte(hit.Id),
+				Value:   hit.Source,
+				Headers: []kafka.Header{{Key: "_index", Value: []byte(hit.Index)}},
+			})
+			if len(batch) == cap(batch) {
+				if err := w.WriteMessages(ctx, batch...); err != nil {
+					return fmt.Errorf("error writing to kafka topic %s: %s", topic, err.Error())
+				}
+				bar.Add64(int64(len(batch)))
+				batch = batch[:0]
+			}
+		}
+		if len(batch) > 0 {
+			if err := w.WriteMessages(ctx, batch...); err != nil {
+				return fmt.Errorf("error writing to kafka topic %s: %s", topic, err.Error())
+			}
+			bar.Add64(int64(len(batch)))
+		}
+		return nil
+	})
+	return nil
+}
+
+// importFromKafka consumes documents from a Kafka topic and bulk indexes
+// them into Elasticsearch. A message value is either one or more
+// newline-delimited raw documents, or lines in the export format. Offsets
+// are committed for the consumer group only after the bulk request with the
+// messages succeeded, so an interrupted import resumes without losing
+// documents. It runs until interrupted, or until no messages have been
+// received for idle (if set).
+func importFromKafka(ctx context.Context, client *elastic.Client, src, group, dstIndex, idField string, idle time.Duration) error {
+	brokers, topic, err := parseKafkaURL(src)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := kafkaTLSConfig()
+	if err != nil {
+		return fmt.Errorf("error configuring kafka tls: %s", err.Error())
+	}
+	mechanism, err := kafkaMechanism()
+	if err != nil {
+		return fmt.Errorf("error configuring kafka sasl: %s", err.Error())
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: group,
+		Dialer: &kafka.Dialer{
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+		MaxBytes: 10e6,
+	})
+	defer r.Close()
+
+	var msgs []kafka.Message
+	lastMsg := time.Now()
+	for {
+		// Wait at most a second for a message so partial batches are flushed.
+		fctx, cancel := context.WithTimeout(ctx, time.Second)
+		m, err := r.FetchMessage(fctx)
+		cancel()
+		switch {
+		case err == nil:
+			msgs = append(msgs, m)
+			lastMsg = time.Now()
+			if len(msgs) < *importBatch {
+				continue
+			}
+		case ctx.Err() != nil:
+			// Interrupted, index what has been read so far and stop.
+			return flushKafkaMessages(context.Background(), client, r, msgs, dstIndex, idField)
+		case fctx.Err() == nil:
+			return fmt.Errorf("error reading from kafka topic %s: %s", topic, err.Error())
+		}
+		if err := flushKafkaMessages(ctx, client, r, msgs, dstIndex, idField); err != nil {
+			return err
+		}
+		msgs = msgs[:0]
+		if idle > 0 && time.Since(lastMsg) > idle {
+			return nil
+		}
+	}
+}
+
+// flushKafkaMessages bulk indexes the documents in msgs and commits their
+// offsets once the bulk request succeeded.
+func flushKafkaMessages(ctx context.Context, client *elastic.Client, r *kafka.Reader, msgs []kafka.Message, dstIndex, idField string) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	batch := make([]message, len(msgs))
+	for i, m := range msgs {
+		batch[i] = message{key: string(m.Key), value: m.Value}
+	}
+	if err := bulkIndexMessages(ctx, client, batch, dstIndex, idField); err != nil {
+		return err
+	}
+	if err := r.CommitMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("error committing kafka offsets: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+var (
+	// Store the credentials of a cluster profile in the OS keyring
+	loginCmd      = app.Command("login", "Store the credentials of a cluster profile in the keyring of the OS (macOS Keychain, Secret Service or Windows Credential Manager), used when the profile has no password or API key")
+	loginProfile  = loginCmd.Arg("profile", "Profile of the config file to store the credentials of").Required().String()
+	loginUsername = loginCmd.Flag("username", "Username to log in as (default: the username of the profile)").String()
+	loginAPIKey   = loginCmd.Flag("api-key", "Store an API key instead of a username and password").Bool()
+	loginDelete   = loginCmd.Flag("delete", "Remove the stored credentials of the profile instead").Bool()
+)
+
+// keyringService is the name the credentials are stored under in the
+// keyring, with the name of the profile as the user.
+const keyringService = "elastic-vandelay"
+
+// storedCredentials are the credentials of a profile in the keyring.
+type storedCredentials struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	APIKey   string `json:"api_key,omitempty"`
+}
+
+func doLogin() error {
+	name := *loginProfile
+	if *loginDelete {
+		if err := keyring.Delete(keyringService, name); err != nil {
+			return fmt.Errorf("error removing the credentials of profile %s: %s", name, err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "removed the credentials of profile %s\n", name)
+		return nil
+	}
+	config, err := readConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	p, ok := config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile %s in %s", name, *configFile)
+	}
+
+	var creds storedCredentials
+	if *loginAPIKey {
+		if creds.APIKey, err = readSecret("API key: "); err != nil {
+			return err
+		}
+	} else {
+		creds.Username = *loginUsername
+		if creds.Username == "" {
+			creds.Username = p.Username
+		}
+		if creds.Username == "" {
+			return fmt.Errorf("the profile %s has no username, use --username", name)
+		}
+		if creds.Password, err = readSecret(fmt.Sprintf("Password of %s: ", creds.Username)); err != nil {
+			return err
+		}
+	}
+
+	// Check the credentials before storing them.
+	p.Username, p.Password, p.APIKey = creds.Username, creds.Password, creds.APIKey
+	u, err := p.url()
+	if err != nil {
+		return fmt.Errorf("invalid url of profile %s: %s", name, err.Error())
+	}
+	*sourceHeaders = append(*sourceHeaders, p.headers()...)
+	*sourceCACert = expandHome(p.CACert)
+	*sourceInsecure = p.Insecure
+	client, _, err := newElasticClient(u, sourceSide)
+	if err != nil {
+		return fmt.Errorf("cannot log in to %s: %s", redact(u), err.Error())
+	}
+	user := creds.Username
+	if who, err := pingUser(client); err == nil {
+		user = who
+	}
+
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	if err = keyring.Set(keyringService, name, string(b)); err != nil {
+		return fmt.Errorf("error storing the credentials of profile %s: %s", name, err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "logged in to profile %s as %s\n", name, user)
+	return nil
+}
+
+// readSecret reads a secret from the terminal without echoing it, or a
+// line of stdin when it is not a terminal.
+func readSecret(prompt string) (string, error) {
+	var secret string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		secret = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading the secret from stdin: %s", err.Error())
+		}
+		secret = line
+	}
+	if secret = strings.TrimSpace(secret); secret == "" {
+		return "", fmt.Errorf("empty secret")
+	}
+	return secret, nil
+}
+
+// fromKeyring sets the credentials of a profile without a password or API
+// key from the keyring, if some were stored with login. A keyring that is
+// not available counts as no credentials.
+func (p *clusterProfile) fromKeyring(name string) error {
+	if p.Password != "" || p.APIKey != "" {
+		return nil
+	}
+	secret, err := keyring.Get(keyringService, name)
+	if err != nil {
+		return nil
+	}
+	var creds storedCredentials
+	if err = json.Unmarshal([]byte(secret), &creds); err != nil {
+		return fmt.Errorf("invalid credentials of profile %s in the keyring: %s", name, err.Error())
+	}
+	if creds.Username != "" {
+		p.Username = creds.Username
+	}
+	p.Password, p.APIKey = creds.Password, creds.APIKey
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+var (
+	// Show the mappings saved with an export
+	mappingCmd   = app.Command("mapping", "Print the mappings (and settings, if saved) of the indices of an export file")
+	mappingFile  = mappingCmd.Flag("file", "Export file whose mappings to print (the mappings file next to it is read)").Required().String()
+	mappingIndex = mappingCmd.Flag("index", "Only print the mappings of this index of the export").String()
+	mappingPut   = mappingCmd.Flag("put", "Print a PUT request for each index, ready to paste into the Kibana console to create it").Bool()
+)
+
+// nonSettableSettings are index settings that are returned by Elasticsearch
+// but cannot be set when creating an index.
+var nonSettableSettings = []string{
+	"index.creation_date", "index.uuid", "index.version.", "index.provided_name",
+	"index.resize.", "index.routing.allocation.initial_recovery.", "index.history.uuid",
+}
+
+func doMapping() error {
+	m, err := readMappingsFromFile(*mappingFile)
+	if err != nil {
+		return err
+	}
+	settings, err := readSettingsFromFile(*mappingFile)
+	if err != nil {
+		return err
+	}
+	indices := gjson.ParseBytes(m).Map()
+	names := make([]string, 0, len(indices))
+	for name := range indices {
+		if *mappingIndex == "" || name == *mappingIndex {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("index %s is not in %s", *mappingIndex, mappingsFileName(*mappingFile))
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		body := map[string]interface{}{"mappings": indices[name].Get("mappings").Value()}
+		if s, ok := settings[name]; ok {
+			body["settings"] = creatableSettings(s)
+		}
+		b, err := json.MarshalIndent(body, "", "  ")
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if *mappingPut {
+			fmt.Printf("PUT /%s\n%s\n", name, b)
+		} else {
+			fmt.Printf("# %s\n%s\n", name, b)
+		}
+	}
+	return nil
+}
+
+// readSettingsFromFile reads the flat index settings saved next to an
+// export, by index, or nil if there are none.
+func readSettingsFromFile(file string) (map[string]map[string]interface{}, error) {
+	f := companionFile(file, "settings")
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("error parsing settings file %s: %s", f, err.Error())
+	}
+	settings := map[string]map[string]interface{}{}
+	for index, is := range s {
+		settings[index] = is.Settings
+	}
+	return settings, nil
+}
+
+// creatableSettings returns flat index settings without the ones that
+// are set by Elasticsearch and cannot be given to create an index.
+func creatableSettings(s map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range s {
+		drop := false
+		for _, prefix := range nonSettableSettings {
+			if k == prefix || strings.HasSuffix(prefix, ".") && strings.HasPrefix(k, prefix) {
+				drop = true
+			}
+		}
+		if !drop {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var strictMappings = app.Flag("strict-mappings", "Fail before creating an index whose mappings have parameters the destination cluster does not support, instead of removing them with a warning").Bool()
+
+// removedParams are mapping parameters, of the mappings or of fields, and
+// the Elasticsearch version that rejects them. OpenSearch rejects the ones
+// removed up to 7.10, the version it was forked from.
+var removedParams = map[string]string{
+	"_timestamp": "5.0", "_ttl": "5.0", "_all": "7.0", "_parent": "7.0", "_field_names": "8.0",
+	"lat_lon": "5.0", "geohash": "5.0", "geohash_prefix": "5.0", "geohash_precision": "5.0",
+	"precision_step": "5.0", "position_offset_gap": "5.0", "include_in_all": "7.0", "boost": "8.0",
+}
+
+// mappingFeature is the first Elasticsearch and OpenSearch versions that
+// support a mapping parameter or field type, "" if one never did.
+type mappingFeature struct {
+	elasticsearch string
+	opensearch    string
+}
+
+// addedParams are mapping parameters, of the mappings or of fields, that
+// older versions reject.
+var addedParams = map[string]mappingFeature{
+	"meta":                  {"7.6", "1.0"},
+	"runtime":               {"7.11", ""},
+	"script":                {"7.11", ""},
+	"on_script_error":       {"7.11", ""},
+	"subobjects":            {"8.3", ""},
+	"time_series_dimension": {"8.0", ""},
+	"time_series_metric":    {"8.0", ""},
+}
+
+// addedTypes are the field types that older versions, or the other
+// product, do not have.
+var addedTypes = map[string]mappingFeature{
+	"alias":                   {"6.4", "1.0"},
+	"rank_feature":            {"7.0", "1.0"},
+	"rank_features":           {"7.0", "1.0"},
+	"dense_vector":            {"7.0", ""},
+	"search_as_you_type":      {"7.2", "1.0"},
+	"flattened":               {"7.3", ""},
+	"histogram":               {"7.6", ""},
+	"constant_keyword":        {"7.7", ""},
+	"wildcard":                {"7.9", "2.15"},
+	"version":                 {"7.10", ""},
+	"unsigned_long":           {"7.10", "2.8"},
+	"aggregate_metric_double": {"7.11", ""},
+	"match_only_text":         {"7.14", "2.12"},
+	"semantic_text":           {"8.15", ""},
+	"flat_object":             {"", "2.7"},
+	"knn_vector":              {"", "1.0"},
+}
+
+// unsupportedMapping is a part of the mappings the cluster rejects.
+type unsupportedMapping struct {
+	field  string // "" for the mappings
+	param  string
+	reason string
+	fatal  bool // a field type, which cannot be removed
+}
+
+// String describes the unsupported part, as in a diff of the mappings.
+func (u unsupportedMapping) String() string {
+	if u.field == "" {
+		return fmt.Sprintf("- %s (%s)", u.param, u.reason)
+	}
+	return fmt.Sprintf("- %s: %s (%s)", u.field, u.param, u.reason)
+}
+
+// checkMappings removes the parameters of typeless mappings the cluster
+// rejects, with a warning. It fails instead, with the list of them, if one
+// is the type of a field or with --strict-mappings.
+func checkMappings(mappings map[string]interface{}, c *clusterInfo) error {
+	var found []unsupportedMapping
+	checkParams(mappings, "", c, &found)
+	if props, ok := mappings["properties"].(map[string]interface{}); ok {
+		checkFields(props, "", false, c, &found)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	fatal := *strictMappings
+	lines := make([]string, len(found))
+	for i, u := range found {
+		fatal = fatal || u.fatal
+		lines[i] = u.String()
+	}
+	sort.Strings(lines)
+	if fatal {
+		return fmt.Errorf("mappings not supported by %s %s:\n%s", c.Distribution, c.Version, strings.Join(lines, "\n"))
+	}
+	for _, line := range lines {
+		logger.Printf("warning: removing from the mappings, not supported by %s %s: %s\n", c.Distribution, c.Version, strings.TrimPrefix(line, "- "))
+	}
+	return nil
+}
+
+// checkFields checks the mappings of the fields of an object, and of their
+// objects and subfields. The subfields of multi-fields cannot be copied to
+// other fields from 8.0 on.
+func checkFields(props map[string]interface{}, prefix string, multi bool, c *clusterInfo, found *[]unsupportedMapping) {
+	for name, v := range props {
+		field, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fieldPath := prefix + name
+		checkParams(field, fieldPath, c, found)
+		if t, ok := field["type"].(string); ok {
+			if f, ok := addedTypes[t]; ok {
+				if reason := c.unsupported(f); reason != "" {
+					*found = append(*found, unsupportedMapping{field: fieldPath, param: "type " + t, reason: reason, fatal: true})
+				}
+			}
+		}
+		if _, ok := field["copy_to"]; ok && multi && c.removedIn("8.0") {
+			*found = append(*found, unsupportedMapping{field: fieldPath, param: "copy_to", reason: "not allowed in multi-fields from Elasticsearch 8.0"})
+			if !*strictMappings {
+				delete(field, "copy_to")
+			}
+		}
+		if inner, ok := field["properties"].(map[string]interface{}); ok {
+			checkFields(inner, fieldPath+".", false, c, found)
+		}
+		if sub, ok := field["fields"].(map[string]interface{}); ok {
+			checkFields(sub, fieldPath+".", true, c, found)
+		}
+	}
+}
+
+// checkParams removes the parameters of the mappings of a field, or of
+// the mappings if field is "", that the cluster rejects.
+func checkParams(m map[string]interface{}, field string, c *clusterInfo, found *[]unsupportedMapping) {
+	for param := range m {
+		var reason string
+		if v, ok := removedParams[param]; ok && c.removedIn(v) {
+			reason = "removed in Elasticsearch " + v
+		} else if f, ok := addedParams[param]; ok {
+			reason = c.unsupported(f)
+		}
+		if reason == "" {
+			continue
+		}
+		*found = append(*found, unsupportedMapping{field: field, param: param, reason: reason})
+		if !*strictMappings {
+			delete(m, param)
+		}
+	}
+}
+
+// removedIn returns whether the cluster is at least the Elasticsearch
+// version v, taking OpenSearch as 7.10.
+func (c *clusterInfo) removedIn(v string) bool {
+	if c.Distribution == "opensearch" {
+		return versionAtLeast(7, 10, v)
+	}
+	return versionAtLeast(c.Major, c.Minor, v)
+}
+
+// unsupported returns why the cluster does not support a feature, or "" if
+// it does.
+func (c *clusterInfo) unsupported(f mappingFeature) string {
+	since, product := f.elasticsearch, "Elasticsearch"
+	if c.Distribution == "opensearch" {
+		since, product = f.opensearch, "OpenSearch"
+	}
+	switch {
+	case since == "":
+		return "not supported by " + product
+	case !versionAtLeast(c.Major, c.Minor, since):
+		return "added in " + product + " " + since
+	}
+	return ""
+}
+
+// versionAtLeast returns whether major.minor is at least the version v.
+func versionAtLeast(major, minor int, v string) bool {
+	parts := strings.SplitN(v, ".", 2)
+	vMajor, _ := strconv.Atoi(parts[0])
+	vMinor := 0
+	if len(parts) > 1 {
+		vMinor, _ = strconv.Atoi(parts[1])
+	}
+	return major > vMajor || major == vMajor && minor >= vMinor
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	// Combine export files into one
+	mergeCmd  = app.Command("merge", "Combine export files into one, e.g. per-day exports into a single archive, with the mappings of all their indices")
+	mergeSrcs = mergeCmd.Arg("sources", "Export files to combine, in order").Required().ExistingFiles()
+	mergeDst  = mergeCmd.Flag("output", "File to write (a file with a '.gz' or '.zst' suffix is compressed)").Short('o').Required().String()
+)
+
+func doMerge() error {
+	for _, src := range *mergeSrcs {
+		if src == *mergeDst {
+			return fmt.Errorf("the output %s is also a source", src)
+		}
+	}
+
+	// Check that the mappings can be combined before writing anything.
+	mappings := map[string]interface{}{}
+	var conflicts []string
+	for _, src := range *mergeSrcs {
+		m, err := readMappingsFromFile(src)
+		if err != nil {
+			logger.Printf("warning: %s\n", err.Error())
+			continue
+		}
+		var indices map[string]interface{}
+		if err = json.Unmarshal(m, &indices); err != nil {
+			return fmt.Errorf("error parsing mappings file %s: %s", mappingsFileName(src), err.Error())
+		}
+		for index, im := range indices {
+			have, ok := mappings[index]
+			if !ok {
+				mappings[index] = im
+				continue
+			}
+			mappings[index] = mergeJSON(have, im, index, &conflicts)
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("the mappings differ: %s", strings.Join(conflicts, ", "))
+	}
+
+	out, err := createExportFile(*mergeDst)
+	if err != nil {
+		return err
+	}
+	var docs int64
+	for _, src := range *mergeSrcs {
+		n, err := appendExportFile(out, src)
+		docs += n
+		if err != nil {
+			out.Close()
+			return err
+		}
+		logger.Printf("added %d documents from %s\n", n, src)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %s", *mergeDst, err.Error())
+	}
+	if len(mappings) > 0 {
+		if err = writeMappingsToFile(trimCompression(*mergeDst), mappings); err != nil {
+			return err
+		}
+	}
+	for _, suffix := range []string{"settings", "ilm", "pipelines"} {
+		if err = mergeCompanions(*mergeSrcs, *mergeDst, suffix); err != nil {
+			return err
+		}
+	}
+	logger.Printf("merged %d documents of %d files into %s\n", docs, len(*mergeSrcs), *mergeDst)
+	return nil
+}
+
+// appendExportFile writes the documents of an export file to w, and
+// returns how many there were.
+func appendExportFile(w *compressedFile, src string) (int64, error) {
+	in, err := openExportFile(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	var n int64
+	err = readNDJSON(in, func(line []byte) error {
+		n++
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if err != nil {
+		return n, fmt.Errorf("error copying %s: %s", src, err.Error())
+	}
+	return n, nil
+}
+
+// mergeJSON returns the union of two decoded JSON objects, such as the
+// mappings of an index in two exports. Values other than objects must be
+// the same in both, or the path to them is added to conflicts.
+func mergeJSON(a, b interface{}, path string, conflicts *[]string) interface{} {
+	ma, okA := a.(map[string]interface{})
+	mb, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			*conflicts = append(*conflicts, fmt.Sprintf("%s is %v and %v", path, a, b))
+		}
+		return a
+	}
+	merged := make(map[string]interface{}, len(ma))
+	for k, v := range ma {
+		merged[k] = v
+	}
+	for k, v := range mb {
+		if have, ok := merged[k]; ok {
+			merged[k] = mergeJSON(have, v, path+"."+k, conflicts)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// mergeCompanions writes the union of the files with a suffix saved next
+// to each source (settings, ILM policies or pipelines) next to dst. The
+// first source wins where they differ.
+func mergeCompanions(srcs []string, dst, suffix string) error {
+	var merged map[string]interface{}
+	for _, src := range srcs {
+		f := companionFile(src, suffix)
+		b, err := ioutil.ReadFile(f)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var m map[string]interface{}
+		if err = json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("error parsing %s: %s", f, err.Error())
+		}
+		if merged == nil {
+			merged = m
+			continue
+		}
+		var ignored []string
+		merged = mergeJSON(merged, m, suffix, &ignored).(map[string]interface{})
+	}
+	if merged == nil {
+		return nil
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(companionFile(dst, suffix), b, 0644)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+)
+
+// NATS connection settings, shared by the export and import commands.
+var (
+	natsCreds   = app.Flag("nats-creds", "NATS credentials file to authenticate with").ExistingFile()
+	natsDurable = app.Flag("nats-durable", "Name of the durable JetStream consumer to use when importing from a stream").Default("elastic-vandelay").String()
+)
+
+// connectNATS connects to the server in a nats://host:4222/name URL and
+// returns the JetStream context and the name in the URL path (the subject
+// to publish to or the stream to consume from).
+func connectNATS(s string) (*nats.Conn, jetstream.JetStream, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("invalid nats url %s: %s", s, err.Error())
+	}
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		return nil, nil, "", fmt.Errorf("invalid nats url %s - use nats://host:4222/name", s)
+	}
+	opts := []nats.Option{nats.Name("elastic-vandelay")}
+	if *natsCreds != "" {
+		opts = append(opts, nats.UserCredentials(*natsCreds))
+	}
+	u.Path = ""
+	nc, err := nats.Connect(u.String(), opts...)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error connecting to nats %s: %s", u.Host, err.Error())
+	}
+	js, err := jetstream.New(nc, jetstream.WithPublishAsyncMaxPending(size))
+	if err != nil {
+		nc.Close()
+		return nil, nil, "", err
+	}
+	return nc, js, name, nil
+}
+
+// writeDataToNATS publishes the _source of each document sent on channel to
+// a JetStream subject, with the _id and _index as message headers. The
+// subject must be bound to a stream. Publishing is asynchronous, but at most
+// size messages are left unacknowledged and every ack is checked, so the
+// export fails if the stream rejects any document.
+func writeDataToNATS(ctx context.Context, g *errgroup.Group, dst string, hits chan interface{}) error {
+	nc, js, subject, err := connectNATS(dst)
+	if err != nil {
+		return err
+	}
+
+	g.Go(func() error {
+		defer nc.Close()
+		futures := make([]jetstream.PubAckFuture, 0, size)
+		wait := func() error {
+			for _, f := range futures {
+				select {
+				case <-f.Ok():
+				case err := <-f.Err():
+					return fmt.Errorf("error publishing to nats subject %s: %s", subject, err.Error())
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			bar.Add64(int64(len(futures)))
+			futures = futures[:0]
+			return nil
+		}
+		for h := range hits {
+			hit, err := toHit(h)
+			if err != nil {
+				return err
+			}
+			msg := nats.NewMsg(subject)
+			msg.Data = hit.Source
+			msg.Header.Set("_id", hit.Id)
+			msg.Header.Set("_index", hit.Index)
+			f, err := js.PublishMsgAsync(msg)
+			if err != nil {
+				return fmt.Errorf("error publishing to nats subject %s: %s", subject, err.Error())
+			}
+			futures = append(futures, f)
+			if len(futures) == cap(futures) {
+				if err := wait(); err != nil {
+					return err
+				}
+			}
+		}
+		return wait()
+	})
+	return nil
+}
+
+// importFromNATS consumes documents from a JetStream stream with a durable
+// consumer and bulk indexes them into Elasticsearch. Messages are only
+// acknowledged after the bulk request with them succeeded, so an
+// interrupted import resumes without losing documents. It runs until
+// interrupted, or until no messages have been received for idle (if set).
+func importFromNATS(ctx context.Context, client *elastic.Client, src, dstIndex, idField string, idle time.Duration) error {
+	nc, js, stream, err := connectNATS(src)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	cons, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:   *natsDurable,
+		AckPolicy: jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating consumer for nats stream %s: %s", stream, err.Error())
+	}
+
+	lastMsg := time.Now()
+	for ctx.Err() == nil {
+		batch, err := cons.Fetch(*importBatch, jetstream.FetchMaxWait(time.Second))
+		if err != nil {
+			return fmt.Errorf("error reading from nats stream %s: %s", stream, err.Error())
+		}
+		var msgs []jetstream.Msg
+		var docs []message
+		for m := range batch.Messages() {
+			msgs = append(msgs, m)
+			docs = append(docs, message{key: m.Headers().Get("_id"), value: m.Data()})
+		}
+		if err := batch.Error(); err != nil && ctx.Err() == nil {
+			return fmt.Errorf("error reading from nats stream %s: %s", stream, err.Error())
+		}
+		if len(msgs) == 0 {
+			if idle > 0 && time.Since(lastMsg) > idle {
+				return nil
+			}
+			continue
+		}
+		lastMsg = time.Now()
+		if err := bulkIndexMessages(context.Background(), client, docs, dstIndex, idField); err != nil {
+			for _, m := range msgs {
+				m.Nak()
+			}
+			return err
+		}
+		for _, m := range msgs {
+			if err := m.Ack(); err != nil {
+				return fmt.Errorf("error acknowledging nats message: %s", err.Error())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Notification settings, shared by all commands.
+var (
+	notifyURL    = app.Flag("notify-url", "URL to POST a summary to when the command completes or fails").String()
+	notifyFormat = app.Flag("notify-format", "Format of the notification: a generic JSON summary, or a Slack compatible message").Default("json").Enum("json", "slack")
+)
+
+// runSummary describes the outcome of a command for notifications.
+type runSummary struct {
+	Command     string `json:"command"`
+	Status      string `json:"status"`
+	Host        string `json:"host"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Documents   int64  `json:"documents"`
+	Failed      int64  `json:"failed,omitempty"`
+	Started     string `json:"started"`
+	Duration    string `json:"duration"`
+	Error       string `json:"error,omitempty"`
+}
+
+// summary is filled in by the commands as they run.
+var summary runSummary
+
+// summaryFileEnv names the environment variable that a parent process (such
+// as the run command) sets to have the summary written to a file.
+const summaryFileEnv = "VANDELAY_SUMMARY_FILE"
+
+// runAndNotify runs a command and sends a notification with the summary if
+// --notify-url is set. A failure to notify is logged but does not change
+// the result of the command.
+func runAndNotify(command string, fn func() error) error {
+	startTime := time.Now()
+	err := fn()
+	f := os.Getenv(summaryFileEnv)
+	if *notifyURL == "" && f == "" {
+		return err
+	}
+
+	summary.Command = command
+	summary.Source = redact(summary.Source)
+	summary.Destination = redact(summary.Destination)
+	summary.Status = "completed"
+	summary.Host, _ = os.Hostname()
+	summary.Documents = atomic.LoadInt64(&docCount)
+	summary.Failed = failedCount()
+	summary.Started = startTime.Format(time.RFC3339)
+	summary.Duration = time.Since(startTime).Round(time.Second).String()
+	if err != nil {
+		summary.Status = "failed"
+		summary.Error = err.Error()
+	}
+	if f != "" {
+		b, _ := json.Marshal(summary)
+		if werr := ioutil.WriteFile(f, b, 0644); werr != nil {
+			logger.Printf("error writing summary to %s: %s\n", f, werr)
+		}
+	}
+	if *notifyURL == "" {
+		return err
+	}
+	if nerr := sendNotification(*notifyURL, *notifyFormat, summary); nerr != nil {
+		logger.Printf("error sending notification to %s: %s\n", *notifyURL, nerr)
+	}
+	return err
+}
+
+// redact hides the password in a URL, so credentials are not sent along in
+// notifications.
+func redact(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// sendNotification posts the summary to a webhook.
+func sendNotification(webhook, format string, s runSummary) error {
+	var payload interface{} = s
+	if format == "slack" {
+		text := fmt.Sprintf("elastic-vandelay %s %s on %s: %s -> %s (%d documents in %s)",
+			s.Command, s.Status, s.Host, s.Source, s.Destination, s.Documents, s.Duration)
+		if s.Failed > 0 {
+			text += fmt.Sprintf("\n%d documents failed", s.Failed)
+		}
+		if s.Error != "" {
+			text += "\nError: " + s.Error
+		}
+		payload = map[string]string{"text": text}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c := http.Client{Timeout: 30 * time.Second}
+	res, err := c.Post(webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", res.Status)
+	}
+	return nil
+}
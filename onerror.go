@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	importOnError    = importCmd.Flag("on-error", "What to do with a document that cannot be parsed or that the cluster rejects: skip it, abort the import, or dead-letter to write it to --dead-letter-file and go on").Default("skip").Enum("skip", "abort", "dead-letter")
+	importMaxErrors  = importCmd.Flag("max-errors", "Abort the import once more than this many documents have failed, with --on-error=skip or dead-letter (0 for no limit)").Default("0").Int64()
+	importDeadLetter = importCmd.Flag("dead-letter-file", "File to append the documents that failed to with --on-error=dead-letter, one JSON object per line with the error").String()
+)
+
+var (
+	// malformedCount is the number of lines that could not be parsed, and
+	// rejectedCount the number of documents the cluster rejected, for the
+	// run summary.
+	malformedCount int64
+	rejectedCount  int64
+
+	// deadLetters is the --dead-letter-file of the import, if any.
+	deadLetters *deadLetterFile
+)
+
+// deadLetter is a line of the dead-letter file: a rejected document in the
+// format of an export, so it can be imported again once fixed, or the line
+// that could not be parsed.
+type deadLetter struct {
+	Error   string          `json:"error"`
+	Index   string          `json:"_index,omitempty"`
+	ID      string          `json:"_id,omitempty"`
+	Routing string          `json:"_routing,omitempty"`
+	Source  json.RawMessage `json:"_source,omitempty"`
+	Line    string          `json:"line,omitempty"`
+}
+
+// deadLetterFile is the file the documents that failed are written to,
+// shared by the bulk workers.
+type deadLetterFile struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+// checkOnError fails if the error handling flags cannot be used together.
+func checkOnError() error {
+	if (*importOnError == "dead-letter") != (*importDeadLetter != "") {
+		return fmt.Errorf("--dead-letter-file is required with --on-error=dead-letter, and only used with it")
+	}
+	if *importOnError == "abort" && *importMaxErrors > 0 {
+		return fmt.Errorf("--max-errors cannot be used with --on-error=abort")
+	}
+	return nil
+}
+
+// openDeadLetters opens the --dead-letter-file for appending, so that the
+// documents of earlier runs are kept.
+func openDeadLetters(path string) (*deadLetterFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening dead-letter file: %s", err.Error())
+	}
+	return &deadLetterFile{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// write appends the documents to the file.
+func (d *deadLetterFile) write(letters []deadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, l := range letters {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		d.w.Write(b)
+		if err = d.w.WriteByte('\n'); err != nil {
+			return fmt.Errorf("error writing to dead-letter file: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// close flushes and closes the file, if it is open. The documents that
+// could not be written are lost, so the import must fail on an error.
+func (d *deadLetterFile) close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.f == nil {
+		return nil
+	}
+	f := d.f
+	d.f = nil
+	if err := d.w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing to dead-letter file: %s", err.Error())
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error closing dead-letter file: %s", err.Error())
+	}
+	return nil
+}
+
+// malformedDoc handles a line that cannot be imported as a document, by
+// the --on-error policy. It returns an error to abort the import.
+func malformedDoc(line []byte, err error) error {
+	atomic.AddInt64(&malformedCount, 1)
+	reason := fmt.Sprintf("error unmarshaling json: %s", err.Error())
+	return onError(reason, func() []deadLetter {
+		return []deadLetter{{Error: err.Error(), Line: string(bytes.TrimSpace(line))}}
+	})
+}
+
+// rejectedDocs handles the documents of a bulk request that failed, with
+// their action and source lines, by the --on-error policy. It returns an
+// error to abort the import.
+func rejectedDocs(failed []*elastic.BulkResponseItem, lines []string) error {
+	atomic.AddInt64(&rejectedCount, int64(len(failed)))
+	reason := fmt.Sprintf("error bulk indexing %d documents, first error: %s", len(failed), bulkItemError(failed[0]))
+	return onError(reason, func() []deadLetter {
+		letters := make([]deadLetter, len(failed))
+		for i, item := range failed {
+			letters[i] = deadLetter{Error: bulkItemError(item), Index: item.Index, ID: item.Id}
+			if i < len(lines) {
+				letters[i].Routing, letters[i].Source = bulkSource(lines[i])
+			}
+		}
+		return letters
+	})
+}
+
+// onError skips the documents with a warning, writes them to the dead-letter
+// file or aborts the import, by --on-error. More than --max-errors failed
+// documents abort the import too. The other commands do not set the import
+// flags, and skip the documents.
+func onError(reason string, letters func() []deadLetter) error {
+	switch *importOnError {
+	case "abort":
+		return fmt.Errorf("%s", reason)
+	case "dead-letter":
+		if err := deadLetters.write(letters()); err != nil {
+			return err
+		}
+	}
+	if bar != nil {
+		bar.Clear()
+	}
+	logger.Printf("%s\n", reason)
+	if max := *importMaxErrors; max > 0 && failedCount() > max {
+		return fmt.Errorf("more than %d documents failed, last error: %s", max, reason)
+	}
+	return nil
+}
+
+// failedCount returns the number of documents that could not be imported.
+func failedCount() int64 {
+	return atomic.LoadInt64(&malformedCount) + atomic.LoadInt64(&rejectedCount)
+}
+
+// reportFailed logs how many documents could not be imported, if any.
+func reportFailed() {
+	malformed, rejected := atomic.LoadInt64(&malformedCount), atomic.LoadInt64(&rejectedCount)
+	if malformed+rejected == 0 {
+		return
+	}
+	logger.Printf("\n%d documents failed: %d could not be parsed, %d were rejected\n", malformed+rejected, malformed, rejected)
+	if deadLetters != nil {
+		logger.Printf("the documents that failed were written to %s\n", *importDeadLetter)
+	}
+}
+
+// bulkItemError returns the reason a bulk item failed.
+func bulkItemError(item *elastic.BulkResponseItem) string {
+	if item.Error == nil {
+		return fmt.Sprintf("status %d", item.Status)
+	}
+	return item.Error.Reason
+}
+
+// bulkSource returns the routing and the document of the action and source
+// lines of a bulk request. The document of an upsert is the partial one.
+func bulkSource(lines string) (string, json.RawMessage) {
+	var action, source string
+	if i := strings.IndexByte(lines, '\n'); i >= 0 {
+		action, source = lines[:i], lines[i+1:]
+	}
+	var routing string
+	var update bool
+	gjson.Parse(action).ForEach(func(k, v gjson.Result) bool {
+		routing, update = v.Get("routing").String(), k.Str == "update"
+		return false
+	})
+	doc := gjson.Parse(source)
+	if update {
+		doc = doc.Get("doc")
+	}
+	if !doc.Exists() {
+		return routing, nil
+	}
+	return routing, json.RawMessage(doc.Raw)
+}
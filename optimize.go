@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// bulkSettings are the index settings changed while bulk loading.
+var bulkSettings = map[string]interface{}{
+	"index.refresh_interval":   "-1",
+	"index.number_of_replicas": 0,
+}
+
+// indexOptimizer disables refresh and replicas of the indices imported to,
+// which makes bulk loading much faster, and restores them afterwards.
+type indexOptimizer struct {
+	client *elastic.Client
+
+	mu sync.Mutex
+	// original are the settings of each index before they were changed, nil
+	// for a setting that was not set.
+	original map[string]map[string]interface{}
+}
+
+// optimizer is set with --optimize-bulk, or nil.
+var optimizer *indexOptimizer
+
+// optimize changes the bulk settings of an index, the first time it is
+// called for the index. A missing index is created.
+func (o *indexOptimizer) optimize(ctx context.Context, index string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.original[index]; ok {
+		return nil
+	}
+	exists, err := o.client.IndexExists(index).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error checking if index %s exists: %s", index, err.Error())
+	}
+	if !exists {
+		if _, err = o.client.CreateIndex(index).Do(ctx); err != nil {
+			return fmt.Errorf("error creating index %s: %s", index, err.Error())
+		}
+	}
+	res, err := o.client.IndexGetSettings(index).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting settings of index %s: %s", index, err.Error())
+	}
+	original := map[string]interface{}{}
+	for setting := range bulkSettings {
+		original[setting] = nil
+		if s, ok := res[index]; ok {
+			if v, ok := s.Settings[setting]; ok {
+				original[setting] = v
+			}
+		}
+	}
+	if _, err = o.client.IndexPutSettings(index).BodyJson(bulkSettings).Do(ctx); err != nil {
+		return fmt.Errorf("error changing settings of index %s: %s", index, err.Error())
+	}
+	o.original[index] = original
+	logger.Printf("disabled refresh and replicas of index %s during the import\n", index)
+	return nil
+}
+
+// restore restores the original settings of the indices.
+func (o *indexOptimizer) restore() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var failed error
+	for index, original := range o.original {
+		_, err := o.client.IndexPutSettings(index).BodyJson(original).Do(context.Background())
+		if err != nil {
+			failed = fmt.Errorf("error restoring settings of index %s: %s", index, err.Error())
+			logger.Printf("%s\n", failed)
+			continue
+		}
+		delete(o.original, index)
+	}
+	return failed
+}
+
+// finalizeIndices refreshes the indices, and force merges them down to
+// maxSegments if it is not 0.
+func finalizeIndices(client *elastic.Client, indices map[string]string, maxSegments int) error {
+	ctx := context.Background()
+	for index := range indices {
+		if maxSegments > 0 {
+			logger.Printf("force merging index %s to %d segments\n", index, maxSegments)
+			if _, err := client.Forcemerge(index).MaxNumSegments(maxSegments).Do(ctx); err != nil {
+				return fmt.Errorf("error force merging index %s: %s", index, err.Error())
+			}
+		}
+		if _, err := client.Refresh(index).Do(ctx); err != nil {
+			return fmt.Errorf("error refreshing index %s: %s", index, err.Error())
+		}
+	}
+	return nil
+}
+
+// waitForStatus waits until the indices reach the health status, and fails
+// if they have not after the timeout.
+func waitForStatus(client *elastic.Client, indices map[string]string, status string, timeout time.Duration) error {
+	names := make([]string, 0, len(indices))
+	for index := range indices {
+		names = append(names, index)
+	}
+	logger.Printf("waiting up to %s for the indices to be %s\n", timeout, status)
+	res, err := client.ClusterHealth().Index(names...).WaitForStatus(status).Timeout(fmt.Sprintf("%dms", timeout.Milliseconds())).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error waiting for status %s: %s", status, err.Error())
+	}
+	if res.TimedOut {
+		return fmt.Errorf("indices are %s, not %s, after %s", res.Status, status, timeout)
+	}
+	return nil
+}
+
+// addAliases points the aliases at the indices in a single atomic request,
+// removing them from any other indices they point at, so applications can
+// be switched over to the imported indices.
+func addAliases(client *elastic.Client, indices map[string]string, aliases []string) error {
+	ctx := context.Background()
+	action := client.Alias()
+	for _, alias := range aliases {
+		res, err := client.Aliases().Alias(alias).Do(ctx)
+		if err != nil && !elastic.IsNotFound(err) {
+			return fmt.Errorf("error getting alias %s: %s", alias, err.Error())
+		}
+		if err == nil {
+			for _, index := range res.IndicesByAlias(alias) {
+				if _, ok := indices[index]; !ok {
+					action.Remove(index, alias)
+				}
+			}
+		}
+		for index := range indices {
+			action.Add(index, alias)
+		}
+	}
+	if _, err := action.Do(ctx); err != nil {
+		return fmt.Errorf("error adding aliases: %s", err.Error())
+	}
+	logger.Printf("added aliases %s\n", strings.Join(aliases, ", "))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// indexPlaceholder in --dest-file exports each index of the source to a
+// file of its own, with the placeholder replaced by the name of the index.
+const indexPlaceholder = "{index}"
+
+// perIndexFile returns the export file of an index for a --dest-file with
+// the index placeholder.
+func perIndexFile(file, index string) string {
+	return strings.Replace(file, indexPlaceholder, index, -1)
+}
+
+// checkPerIndexExport fails if an export to a file per index cannot be done
+// with the other flags.
+func checkPerIndexExport(opts exportOptions) error {
+	if opts.followInterval > 0 || opts.maxDocs > 0 || opts.ids != nil {
+		return fmt.Errorf("a --dest-file with %s cannot be used with --follow, --max-docs or --ids-file", indexPlaceholder)
+	}
+	return nil
+}
+
+// exportPerIndex exports each of the indices of an export to a file of its
+// own, with its mappings and the other files saved with an export, one
+// index after the other. They can then be imported on their own.
+func exportPerIndex(client *elastic.Client, opts exportOptions) error {
+	indices := strings.Split(opts.index, ",")
+	sort.Strings(indices)
+	for _, index := range indices {
+		file := perIndexFile(opts.dstFile, index)
+		if _, err := os.Stat(file); err == nil && !isFIFO(file) {
+			return fmt.Errorf("destination file %s already exists", file)
+		}
+	}
+	for i, index := range indices {
+		o := opts
+		o.index = index
+		o.dstFile = perIndexFile(opts.dstFile, index)
+		counter := client.Count(index)
+		if o.query != nil {
+			counter.Query(o.query)
+		}
+		var err error
+		if o.total, err = counter.Do(context.Background()); err != nil {
+			return fmt.Errorf("error counting documents in index %s: %s", index, err.Error())
+		}
+		if *dryRun {
+			err = dryRunExport(client, o)
+		} else {
+			logger.Printf("index %d of %d\n", i+1, len(indices))
+			err = exportData(client, o)
+		}
+		if err != nil {
+			return fmt.Errorf("error exporting index %s: %s", index, err.Error())
+		}
+	}
+	summary.Source = fmt.Sprintf("%s/%s", strings.TrimSuffix(opts.srcURL, "/"), opts.index)
+	summary.Destination = opts.dstFile
+	return nil
+}
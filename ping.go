@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+var (
+	// Check the connection to a cluster
+	pingCmd = app.Command("ping", "Check the connection to a cluster (network, TLS, authentication) and print its version and what elastic-vandelay will use with it")
+	pingURL = pingCmd.Flag("url", "Elasticsearch host to check (http://host:port/)").Required().URL()
+)
+
+// tlsVersions are the names of the TLS versions.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func doPing() error {
+	u := *pingURL
+	start := time.Now()
+	if u.Scheme == "https" {
+		if err := pingTLS(u.String()); err != nil {
+			return err
+		}
+	}
+	client, info, err := newElasticClient(u.String(), sourceSide)
+	if err != nil {
+		return fmt.Errorf("cannot connect to %s: %s", u.Redacted(), err.Error())
+	}
+	fmt.Printf("connected:  %s in %s\n", u.Redacted(), time.Since(start).Round(time.Millisecond))
+	fmt.Printf("version:    %s %s\n", info.Distribution, info.Version)
+
+	if user, err := pingUser(client); err == nil {
+		fmt.Printf("user:       %s\n", user)
+	}
+	if health, err := client.ClusterHealth().Do(rootCtx); err != nil {
+		fmt.Printf("cluster:    unknown (%s)\n", err.Error())
+	} else {
+		fmt.Printf("cluster:    %s, %s, %d nodes (%d data)\n", health.ClusterName, health.Status, health.NumberOfNodes, health.NumberOfDataNodes)
+	}
+
+	paging := "scroll"
+	if info.supportsPIT() {
+		paging = "point in time with search_after"
+	}
+	fmt.Printf("export:     %s\n", paging)
+	mappings := "typeless"
+	if info.typedMappings() {
+		mappings = "with a mapping type (_doc unless --doc-type=preserve)"
+	}
+	fmt.Printf("mappings:   %s\n", mappings)
+	if info.Distribution == "elasticsearch" && info.Major >= 8 {
+		fmt.Printf("requests:   7.x compatibility mode\n")
+	}
+	fmt.Printf("ILM:        %s\n", yesNo(info.supportsILM()))
+	fmt.Printf("hidden:     %s\n", yesNo(info.supportsHidden()))
+	if info.Distribution == "elasticsearch" && info.Major < 6 {
+		return fmt.Errorf("Elasticsearch %s is not supported, use at least 6.0", info.Version)
+	}
+	return nil
+}
+
+// pingTLS connects to an https url and prints the TLS version and the
+// expiry of the server certificate. The response itself is ignored.
+func pingTLS(rawURL string) error {
+	transport, err := newHTTPTransport(sourceSide)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(rootCtx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("cannot connect to %s: %s", redact(rawURL), err.Error())
+	}
+	res.Body.Close()
+	if res.TLS == nil {
+		return nil
+	}
+	tlsInfo := tlsVersions[res.TLS.Version]
+	if certs := res.TLS.PeerCertificates; len(certs) > 0 {
+		expires := certs[0].NotAfter
+		tlsInfo += fmt.Sprintf(", certificate of %s valid until %s", certs[0].Subject.CommonName, expires.Format("2006-01-02"))
+		if days := time.Until(expires).Hours() / 24; days < 30 {
+			tlsInfo += fmt.Sprintf(" (%d days left)", int(days))
+		}
+	}
+	fmt.Printf("tls:        %s\n", tlsInfo)
+	return nil
+}
+
+// pingUser returns the user the cluster authenticated the requests as,
+// and their roles.
+func pingUser(client *elastic.Client) (string, error) {
+	res, err := client.PerformRequest(rootCtx, elastic.PerformRequestOptions{Method: "GET", Path: "/_security/_authenticate"})
+	if err != nil {
+		return "", err
+	}
+	var user struct {
+		Username string   `json:"username"`
+		Roles    []string `json:"roles"`
+	}
+	if err = json.Unmarshal(res.Body, &user); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %v", user.Username, user.Roles), nil
+}
+
+// yesNo returns yes or no.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// pipelineSettings are the index settings that refer to an ingest pipeline.
+var pipelineSettings = []string{"index.default_pipeline", "index.final_pipeline"}
+
+// pipelinesExport is the ingest pipelines the exported indices refer to,
+// saved next to the export as <name>-pipelines.json.
+type pipelinesExport struct {
+	// Indices are the pipeline settings of each index.
+	Indices map[string]map[string]string `json:"indices"`
+	// Pipelines are the pipelines by id.
+	Pipelines map[string]json.RawMessage `json:"pipelines"`
+}
+
+// readPipelinesFromElastic returns the ingest pipelines the indices refer to
+// in their settings, or nil if there are none.
+func readPipelinesFromElastic(client *elastic.Client, index string) (*pipelinesExport, error) {
+	ctx := context.Background()
+	res, err := client.IndexGetSettings(index).FlatSettings(true).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting settings of index %s: %s", index, err.Error())
+	}
+	e := &pipelinesExport{Indices: map[string]map[string]string{}, Pipelines: map[string]json.RawMessage{}}
+	for name, s := range res {
+		for _, setting := range pipelineSettings {
+			id, ok := s.Settings[setting].(string)
+			if !ok || id == "" || id == "_none" {
+				continue
+			}
+			if e.Indices[name] == nil {
+				e.Indices[name] = map[string]string{}
+			}
+			e.Indices[name][setting] = id
+			if _, ok := e.Pipelines[id]; ok {
+				continue
+			}
+			p, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+				Method: "GET",
+				Path:   "/_ingest/pipeline/" + url.PathEscape(id),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error getting ingest pipeline %s: %s", id, err.Error())
+			}
+			var pipelines map[string]json.RawMessage
+			if err = json.Unmarshal(p.Body, &pipelines); err != nil {
+				return nil, fmt.Errorf("error parsing ingest pipeline %s: %s", id, err.Error())
+			}
+			e.Pipelines[id] = pipelines[id]
+		}
+	}
+	if len(e.Indices) == 0 {
+		return nil, nil
+	}
+	return e, nil
+}
+
+// exportPipelines saves the ingest pipelines the indices refer to next to
+// the export, if there are any.
+func exportPipelines(client *elastic.Client, index, file string) error {
+	e, err := readPipelinesFromElastic(client, index)
+	if err != nil || e == nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	logger.Printf("saving ingest pipelines to %s\n", companionFile(file, "pipelines"))
+	return ioutil.WriteFile(companionFile(file, "pipelines"), b, 0644)
+}
+
+// readPipelinesFromFile reads the ingest pipelines saved next to an export,
+// or nil if there are none.
+func readPipelinesFromFile(file string) (*pipelinesExport, error) {
+	f := companionFile(file, "pipelines")
+	b, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e pipelinesExport
+	if err = json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("error parsing pipelines file %s: %s", f, err.Error())
+	}
+	return &e, nil
+}
+
+// writePipelinesToElastic creates the ingest pipelines that do not exist
+// yet. Existing pipelines are left as they are.
+func writePipelinesToElastic(client *elastic.Client, e *pipelinesExport) error {
+	ctx := context.Background()
+	for id, p := range e.Pipelines {
+		_, err := client.IngestGetPipeline(id).Do(ctx)
+		if err == nil {
+			logger.Printf("ingest pipeline %s already exists, leaving it as is\n", id)
+			continue
+		}
+		if !elastic.IsNotFound(err) {
+			return fmt.Errorf("error getting ingest pipeline %s: %s", id, err.Error())
+		}
+		if _, err = client.IngestPutPipeline(id).BodyString(string(p)).Do(ctx); err != nil {
+			return fmt.Errorf("error creating ingest pipeline %s: %s", id, err.Error())
+		}
+	}
+	return nil
+}
+
+// settingsFor returns the pipeline settings of an exported index, or of
+// the only index if index is not in the export.
+func (e *pipelinesExport) settingsFor(index string) map[string]string {
+	if s, ok := e.Indices[index]; ok {
+		return s
+	}
+	if len(e.Indices) == 1 {
+		for _, s := range e.Indices {
+			return s
+		}
+	}
+	return nil
+}
+
+// attachPipelines sets the pipeline settings of the imported indices. This
+// is done after the import, so the documents, which have already been
+// through the pipelines, are not processed again. indices are the imported
+// indices with the original index of each.
+func attachPipelines(client *elastic.Client, e *pipelinesExport, indices map[string]string) error {
+	for index, orig := range indices {
+		s := e.settingsFor(orig)
+		if len(s) == 0 {
+			continue
+		}
+		if _, err := client.IndexPutSettings(index).BodyJson(s).Do(context.Background()); err != nil {
+			return fmt.Errorf("error setting ingest pipelines of index %s: %s", index, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"golang.org/x/sync/errgroup"
+)
+
+// postgresTypes maps elasticsearch field types to postgres column types.
+// Fields of any other type are only available in the jsonb column.
+var postgresTypes = map[string]string{
+	"keyword":          "text",
+	"constant_keyword": "text",
+	"wildcard":         "text",
+	"text":             "text",
+	"ip":               "text",
+	"date":             "text",
+	"date_nanos":       "text",
+	"long":             "bigint",
+	"integer":          "integer",
+	"short":            "smallint",
+	"byte":             "smallint",
+	"unsigned_long":    "numeric",
+	"boolean":          "boolean",
+	"double":           "double precision",
+	"float":            "real",
+	"half_float":       "real",
+	"scaled_float":     "double precision",
+}
+
+// postgresTable buffers the rows for one index until they are copied in.
+type postgresTable struct {
+	cols []sqlColumn
+	rows [][]interface{}
+}
+
+// writeDataToPostgres writes each document sent on channel into postgres,
+// one table per index, using COPY in batches. Each table has the _id, the
+// _source as jsonb and one column per scalar field in the index mappings.
+// The mappings themselves are saved in the _mappings table.
+func writeDataToPostgres(ctx context.Context, g *errgroup.Group, dsn string, mappings map[string]interface{}, hits chan interface{}) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("unable to connect to postgres: %s", err.Error())
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return fmt.Errorf("unable to connect to postgres: %s", err.Error())
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS _mappings (index_name text PRIMARY KEY, mapping jsonb)`)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("unable to create mappings table: %s", err.Error())
+	}
+	for index, m := range mappings {
+		b, _ := json.Marshal(m)
+		_, err = db.Exec(`INSERT INTO _mappings (index_name, mapping) VALUES ($1, $2)
+			ON CONFLICT (index_name) DO UPDATE SET mapping = EXCLUDED.mapping`, index, string(b))
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("unable to save mappings for %s: %s", index, err.Error())
+		}
+	}
+
+	g.Go(func() error {
+		defer db.Close()
+		tables := map[string]*postgresTable{}
+		for h := range hits {
+			hit, err := toHit(h)
+			if err != nil {
+				return err
+			}
+			t, ok := tables[hit.Index]
+			if !ok {
+				t = &postgresTable{cols: mappingColumns(mappings, hit.Index, postgresTypes)}
+				if err := createPostgresTable(db, hit.Index, t.cols); err != nil {
+					return err
+				}
+				tables[hit.Index] = t
+			}
+			row := []interface{}{hit.Id, string(hit.Source)}
+			for _, c := range t.cols {
+				v := columnValue(hit.Source, c.Name)
+				// Arrays and objects only fit in text columns.
+				if s, ok := v.(string); ok && c.Type != "text" && strings.HasPrefix(s, "[") {
+					v = nil
+				}
+				row = append(row, v)
+			}
+			t.rows = append(t.rows, row)
+			if len(t.rows) >= size {
+				if err := copyToPostgres(db, hit.Index, t); err != nil {
+					return err
+				}
+			}
+
+			bar.Add64(1)
+
+			// Terminate early?
+			select {
+			default:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for index, t := range tables {
+			if err := copyToPostgres(db, index, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// createPostgresTable creates the table for an index.
+func createPostgresTable(db *sql.DB, index string, cols []sqlColumn) error {
+	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (_id text PRIMARY KEY, _source jsonb", pq.QuoteIdentifier(index))
+	for _, c := range cols {
+		q += ", " + pq.QuoteIdentifier(c.Name) + " " + c.Type
+	}
+	q += ")"
+	if _, err := db.Exec(q); err != nil {
+		return fmt.Errorf("unable to create table for index %s: %s", index, err.Error())
+	}
+	return nil
+}
+
+// postgresCopyTable is the temporary table the rows are copied into before
+// they are upserted into the table of their index.
+const postgresCopyTable = "_vandelay_copy"
+
+// copyToPostgres copies the buffered rows of a table in a single
+// transaction and resets the buffer. The rows are copied into a temporary
+// table and upserted from it, so that exporting documents again, or with
+// --follow, replaces their rows as with SQLite.
+func copyToPostgres(db *sql.DB, index string, t *postgresTable) error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	names := []string{"_id", "_source"}
+	for _, c := range t.cols {
+		names = append(names, c.Name)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tx.Rollback()
+		return fmt.Errorf("unable to copy into table %s: %s", index, err.Error())
+	}
+	table := pq.QuoteIdentifier(index)
+	_, err = tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", pq.QuoteIdentifier(postgresCopyTable), table))
+	if err != nil {
+		return fail(err)
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(postgresCopyTable, names...))
+	if err != nil {
+		return fail(err)
+	}
+	for _, row := range lastRows(t.rows) {
+		if _, err = stmt.Exec(row...); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return fail(err)
+	}
+	if err = stmt.Close(); err != nil {
+		return fail(err)
+	}
+	cols := make([]string, len(names))
+	var updates []string
+	for i, name := range names {
+		cols[i] = pq.QuoteIdentifier(name)
+		if i > 0 {
+			updates = append(updates, cols[i]+" = EXCLUDED."+cols[i])
+		}
+	}
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (_id) DO UPDATE SET %s",
+		table, strings.Join(cols, ", "), strings.Join(cols, ", "), pq.QuoteIdentifier(postgresCopyTable), strings.Join(updates, ", ")))
+	if err != nil {
+		return fail(err)
+	}
+	t.rows = t.rows[:0]
+	return tx.Commit()
+}
+
+// lastRows returns the rows, keeping only the last one of each _id, which
+// an upsert cannot update twice.
+func lastRows(rows [][]interface{}) [][]interface{} {
+	last := make(map[interface{}]int, len(rows))
+	for i, row := range rows {
+		last[row[0]] = i
+	}
+	if len(last) == len(rows) {
+		return rows
+	}
+	out := make([][]interface{}, 0, len(last))
+	for i, row := range rows {
+		if last[row[0]] == i {
+			out = append(out, row)
+		}
+	}
+	return out
+}
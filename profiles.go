@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	configFile    = app.Flag("profiles", "YAML file with the cluster profiles").Default(filepath.Join("~", ".vandelay.yaml")).Envar("VANDELAY_CONFIG").String()
+	sourceProfile = app.Flag("source-profile", "Profile of the config file to connect to the source cluster with, instead of its URL and credentials").String()
+	destProfile   = app.Flag("dest-profile", "Profile of the config file to connect to the destination cluster with, instead of its URL and credentials").String()
+)
+
+// clusterProfile is a named cluster in the config file: how to connect to
+// it, and default flags for the commands that use it.
+type clusterProfile struct {
+	URL      string                 `yaml:"url"`
+	Username string                 `yaml:"username"`
+	Password string                 `yaml:"password"`
+	APIKey   string                 `yaml:"api_key"`
+	Headers  []string               `yaml:"headers"`
+	CACert   string                 `yaml:"ca_cert"`
+	Insecure bool                   `yaml:"insecure"`
+	Options  map[string]interface{} `yaml:"options"`
+}
+
+// vandelayConfig is the format of the config file.
+type vandelayConfig struct {
+	Profiles map[string]*clusterProfile `yaml:"profiles"`
+}
+
+// Flags set from a profile, by side, most specific first. The URL is set
+// with the first flag of the command that exists.
+var profileURLFlags = map[string][]string{
+	sourceSide: {"source-url", "url"},
+	destSide:   {"dest-url", "dest", "url"},
+}
+
+// expandProfiles returns the command line with --source-profile and
+// --dest-profile replaced by the flags they stand for: the URL of the
+// command, the credentials, TLS options and default options of the
+// profile. Flags given on the command line win over the profile.
+func expandProfiles(args []string) ([]string, error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil || ctx.SelectedCommand == nil {
+		// Let the parser report the error.
+		return args, nil
+	}
+	given := map[string]string{}
+	for _, e := range ctx.Elements {
+		if f, ok := e.Clause.(*kingpin.FlagClause); ok && e.Value != nil {
+			given[f.Model().Name] = *e.Value
+		}
+	}
+	profiles := map[string]string{sourceSide: given["source-profile"], destSide: given["dest-profile"]}
+	if profiles[sourceSide] == "" && profiles[destSide] == "" {
+		return args, nil
+	}
+	file := *configFile
+	if v, ok := given["profiles"]; ok {
+		file = v
+	} else if v := os.Getenv("VANDELAY_CONFIG"); v != "" {
+		file = v
+	}
+	config, err := readConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := ctx.SelectedCommand
+	hasFlag := func(name string) bool {
+		return cmd.GetFlag(name) != nil || app.GetFlag(name) != nil
+	}
+	var extra []string
+	set := func(name, value string) {
+		if _, ok := given[name]; !ok {
+			extra = append(extra, "--"+name+"="+value)
+			given[name] = value
+		}
+	}
+	for _, side := range []string{sourceSide, destSide} {
+		name := profiles[side]
+		if name == "" {
+			continue
+		}
+		p, ok := config.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("no profile %s in %s", name, file)
+		}
+		urlFlag := ""
+		for _, f := range profileURLFlags[side] {
+			if cmd.GetFlag(f) != nil {
+				urlFlag = f
+				break
+			}
+		}
+		if urlFlag == "" {
+			return nil, fmt.Errorf("the %s command has no %s cluster for --%s-profile", cmd.FullCommand(), side, side)
+		}
+		if err = p.fromKeyring(name); err != nil {
+			return nil, err
+		}
+		if err = p.resolveSecrets(); err != nil {
+			return nil, fmt.Errorf("profile %s: %s", name, err.Error())
+		}
+		u, err := p.url()
+		if err != nil {
+			return nil, fmt.Errorf("invalid url of profile %s: %s", name, err.Error())
+		}
+		set(urlFlag, u)
+		for _, h := range p.headers() {
+			extra = append(extra, "--"+side+"-header="+h)
+		}
+		if p.CACert != "" {
+			set(side+"-ca-cert", expandHome(p.CACert))
+		}
+		if _, ok := given[side+"-insecure"]; p.Insecure && !ok {
+			extra = append(extra, "--"+side+"-insecure")
+		}
+		// The options of the profile that the command takes.
+		opts, _ := (&pipelineJob{Command: cmd.FullCommand(), Options: p.Options}).commandLine()
+		for _, opt := range opts[1:] {
+			flag := strings.SplitN(strings.TrimPrefix(opt, "--"), "=", 2)[0]
+			if !hasFlag(flag) {
+				// A false boolean, --no-flag.
+				if flag = strings.TrimPrefix(flag, "no-"); !hasFlag(flag) {
+					continue
+				}
+			}
+			if _, ok := given[flag]; !ok {
+				extra = append(extra, opt)
+			}
+		}
+	}
+	if _, ok := given["debug"]; ok {
+		logger.Printf("profiles add %s\n", redactArgs(extra))
+	}
+	return append(append([]string{}, args...), extra...), nil
+}
+
+// readConfig reads the config file.
+func readConfig(file string) (*vandelayConfig, error) {
+	b, err := ioutil.ReadFile(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err.Error())
+	}
+	var c vandelayConfig
+	if err = yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %s", file, err.Error())
+	}
+	return &c, nil
+}
+
+// url returns the URL of the profile, with its username and password.
+func (p *clusterProfile) url() (string, error) {
+	if p.URL == "" {
+		return "", fmt.Errorf("the url is missing")
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "", err
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u.String(), nil
+}
+
+// resolveSecrets replaces the references to Vault secrets of the
+// credentials and headers of the profile.
+func (p *clusterProfile) resolveSecrets() error {
+	for _, s := range []*string{&p.Username, &p.Password, &p.APIKey} {
+		v, err := resolveVaultRefs(*s, false)
+		if err != nil {
+			return err
+		}
+		*s = v
+	}
+	headers := make([]string, len(p.Headers))
+	for i, h := range p.Headers {
+		v, err := resolveVaultRefs(h, false)
+		if err != nil {
+			return err
+		}
+		headers[i] = v
+	}
+	p.Headers = headers
+	return nil
+}
+
+// headers returns the HTTP headers of the profile, with the API key.
+func (p *clusterProfile) headers() []string {
+	headers := p.Headers
+	if p.APIKey != "" {
+		headers = append(headers, "Authorization: ApiKey "+p.APIKey)
+	}
+	return headers
+}
+
+// expandHome replaces a leading ~ of a path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// redactArgs returns command line arguments with the passwords of URLs and
+// the values of headers hidden, for logging.
+func redactArgs(args []string) string {
+	return strings.Join(redactArgList(args), " ")
+}
+
+// redactArgList returns a copy of the command line arguments with the
+// passwords of URLs and the values of headers hidden.
+func redactArgList(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		parts := strings.SplitN(a, "=", 2)
+		switch {
+		case i > 0 && strings.HasSuffix(args[i-1], "-header") && !strings.HasPrefix(a, "-"):
+			out[i] = strings.SplitN(a, ":", 2)[0] + ": xxxxx"
+		case len(parts) < 2 || !strings.HasPrefix(a, "-"):
+			out[i] = redact(a)
+		case strings.HasSuffix(parts[0], "-header"):
+			out[i] = parts[0] + "=" + strings.SplitN(parts[1], ":", 2)[0] + ": xxxxx"
+		default:
+			out[i] = parts[0] + "=" + redact(parts[1])
+		}
+	}
+	return out
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// Copy an index with the _reindex API of the destination cluster
+	reindexCmd         = app.Command("reindex", "Copy an index from another cluster with the reindex from remote API of the destination cluster, which reads the documents from the source directly rather than through elastic-vandelay")
+	reindexSrcURL      = reindexCmd.Flag("source-url", "Elasticsearch host to copy from (http://host:port/)").Required().URL()
+	reindexSrcIndex    = reindexCmd.Flag("source-index", "Index, pattern or alias to copy").Required().String()
+	reindexRemoteURL   = reindexCmd.Flag("remote-url", "URL the destination cluster reaches the source cluster at, if not --source-url (e.g. its address on an internal network)").URL()
+	reindexDstURL      = reindexCmd.Flag("dest-url", "Elasticsearch host to copy to (http://host:port/)").Required().URL()
+	reindexDstIndex    = reindexCmd.Flag("dest-index", "Index to copy to (default: the name of each source index)").String()
+	reindexQuery       = reindexCmd.Flag("query", "Only copy the documents matching this query: a query string, or a query of the JSON query DSL").String()
+	reindexAppend      = reindexCmd.Flag("append", "Copy into destination indices that already exist").Bool()
+	reindexParallel    = reindexCmd.Flag("parallel", "Number of indices copied at a time, each by a reindex task of its own").Default("1").Int()
+	reindexBatchSize   = reindexCmd.Flag("batch-size", "Number of documents the destination cluster reads from the source at a time").Default("1000").Int()
+	reindexThrottle    = reindexCmd.Flag("requests-per-second", "Throttle the reindex tasks to this many documents per second (0 for no limit)").Default("0").Float()
+	reindexPoll        = reindexCmd.Flag("poll-interval", "How often to check the progress of the reindex tasks").Default("5s").Duration()
+	reindexRetries     = reindexCmd.Flag("retries", "Number of times to run the reindex of an index again if it fails").Default("3").Int()
+	reindexSocketTime  = reindexCmd.Flag("socket-timeout", "How long the destination cluster waits for a response of the source").Default("5m").Duration()
+	reindexConnectTime = reindexCmd.Flag("connect-timeout", "How long the destination cluster waits for a connection to the source").Default("30s").Duration()
+	reindexSkipCheck   = reindexCmd.Flag("skip-whitelist-check", "Do not check that the source is in the reindex.remote.whitelist of the destination cluster before starting").Bool()
+	reindexNoCreateIdx = reindexCmd.Flag("no-create-index", "Do not create the destination indices with the mappings and settings of the source, leaving them to the destination cluster").Bool()
+)
+
+// reindexRetryBackoff is how long to wait before running a failed reindex
+// again.
+const reindexRetryBackoff = 30 * time.Second
+
+// reindexTask is the status of a reindex task, as returned by the tasks API.
+type reindexTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status reindexStatus `json:"status"`
+	} `json:"task"`
+	Response struct {
+		reindexStatus
+		Failures []json.RawMessage `json:"failures"`
+	} `json:"response"`
+	Error *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// reindexStatus counts the documents handled by a reindex task.
+type reindexStatus struct {
+	Total   int64 `json:"total"`
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+	Deleted int64 `json:"deleted"`
+}
+
+// done returns the number of documents copied so far.
+func (s reindexStatus) done() int64 {
+	return s.Created + s.Updated + s.Deleted
+}
+
+func doReindex() error {
+	filter, err := parseQuery(*reindexQuery)
+	if err != nil {
+		return err
+	}
+	remote := *reindexSrcURL
+	if *reindexRemoteURL != nil {
+		remote = *reindexRemoteURL
+	}
+	src, indices, err := connectElasticSource((*reindexSrcURL).String(), *reindexSrcIndex, filter)
+	if err != nil {
+		return err
+	}
+	dst, info, err := newElasticClient((*reindexDstURL).String(), destSide)
+	if err != nil {
+		return err
+	}
+	dstCluster = info
+	summary.Source = fmt.Sprintf("%s/%s", strings.TrimSuffix((*reindexSrcURL).String(), "/"), *reindexSrcIndex)
+	summary.Destination = (*reindexDstURL).String()
+	if !*reindexSkipCheck {
+		if err = checkRemoteWhitelist(dst, remote); err != nil {
+			return err
+		}
+	}
+
+	names := strings.Split(indices.index, ",")
+	sort.Strings(names)
+	targets := map[string]string{}
+	for _, index := range names {
+		targets[index] = index
+		if *reindexDstIndex != "" {
+			targets[index] = *reindexDstIndex
+		}
+	}
+	q := andQuery(filter, indices.filter)
+	if *dryRun {
+		return dryRunReindex(src, dst, names, targets, q, remote)
+	}
+	if !*reindexNoCreateIdx {
+		if err = createReindexTargets(src, dst, names, targets); err != nil {
+			return err
+		}
+	}
+	logger.Printf("reindexing %d documents of %s from %s into %s\n", indices.total, strings.Join(names, ", "), redact(remote.String()), redact((*reindexDstURL).String()))
+
+	startTime := time.Now()
+	bar = progressbar.NewOptions64(indices.total, progressbar.OptionSetRenderBlankState(true), progressbar.OptionSetWriter(os.Stderr))
+	// Cancel the reindex tasks when interrupted.
+	sigCtx, cancel := signal.NotifyContext(rootCtx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	g, ctx := errgroup.WithContext(sigCtx)
+	g.SetLimit(*reindexParallel)
+	for _, index := range names {
+		index := index
+		g.Go(func() error {
+			return reindexIndex(ctx, dst, remote, index, targets[index], q)
+		})
+	}
+	if err = g.Wait(); err != nil {
+		if sigCtx.Err() != nil && rootCtx.Err() == nil {
+			return fmt.Errorf("interrupted, the reindex tasks were cancelled")
+		}
+		return deadlineError(err)
+	}
+	bar.Finish()
+	logger.Printf("\nreindex completed in %s\n", time.Since(startTime).String())
+	return nil
+}
+
+// checkRemoteWhitelist fails, with what to change, unless the host of the
+// source is in the reindex.remote.whitelist of every node of the
+// destination cluster. Without it, the destination rejects the reindex.
+func checkRemoteWhitelist(client *elastic.Client, remote *url.URL) error {
+	res, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_nodes/settings",
+		Params: url.Values{"flat_settings": []string{"true"}, "filter_path": []string{"nodes.*.name,nodes.*.settings"}},
+	})
+	if err != nil {
+		return fmt.Errorf("error getting the settings of the destination nodes: %s", err.Error())
+	}
+	var nodes struct {
+		Nodes map[string]struct {
+			Name     string                 `json:"name"`
+			Settings map[string]interface{} `json:"settings"`
+		} `json:"nodes"`
+	}
+	if err = json.Unmarshal(res.Body, &nodes); err != nil {
+		return fmt.Errorf("error parsing the settings of the destination nodes: %s", err.Error())
+	}
+	host := remoteHostPort(remote)
+	var missing []string
+	for _, node := range nodes.Nodes {
+		if !whitelisted(remoteWhitelist(node.Settings), host) {
+			missing = append(missing, node.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("%s is not in the reindex.remote.whitelist of the destination nodes %s: add\n\n  reindex.remote.whitelist: \"%s\"\n\nto their elasticsearch.yml (or opensearch.yml) and restart them, or use --skip-whitelist-check if the check is wrong", host, strings.Join(missing, ", "), host)
+}
+
+// remoteWhitelist returns the patterns of the reindex.remote.whitelist of
+// the flat settings of a node, or of reindex.remote.allowlist, its name on
+// OpenSearch 2.x.
+func remoteWhitelist(settings map[string]interface{}) []string {
+	v, ok := settings["reindex.remote.whitelist"]
+	if !ok {
+		v = settings["reindex.remote.allowlist"]
+	}
+	switch w := v.(type) {
+	case string:
+		return strings.Split(w, ",")
+	case []interface{}:
+		var out []string
+		for _, p := range w {
+			out = append(out, fmt.Sprint(p))
+		}
+		return out
+	}
+	return nil
+}
+
+// whitelisted returns whether host:port matches one of the patterns.
+func whitelisted(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.TrimSpace(p), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHostPort returns the host:port of a URL, with the default port of
+// its scheme, as in the whitelist.
+func remoteHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// createReindexTargets creates the destination indices that do not exist
+// with the mappings and settings of their source index. A destination
+// index shared by several sources gets those of the first one.
+func createReindexTargets(src, dst *elastic.Client, names []string, targets map[string]string) error {
+	ctx := context.Background()
+	created := map[string]bool{}
+	for _, index := range names {
+		target := targets[index]
+		if created[target] {
+			continue
+		}
+		created[target] = true
+		exists, err := dst.IndexExists(target).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error checking if index %s exists: %s", target, err.Error())
+		}
+		if exists {
+			if !*reindexAppend {
+				return fmt.Errorf("index %s already exists on %s, use --append to copy into it", target, redact((*reindexDstURL).String()))
+			}
+			logger.Printf("index %s already exists, adding to it\n", target)
+			continue
+		}
+		mappings, err := readMappingsFromElastic(src, index)
+		if err != nil {
+			return fmt.Errorf("error getting the mappings of index %s: %s", index, err.Error())
+		}
+		res, err := src.IndexGetSettings(index).FlatSettings(true).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting settings of index %s: %s", index, err.Error())
+		}
+		saved := &importSettings{saved: map[string]map[string]interface{}{}}
+		for name, s := range res {
+			saved.saved[name] = s.Settings
+		}
+		m, _ := mappings[index].(map[string]interface{})
+		adapted, err := adaptMappings(m["mappings"], dstCluster)
+		if err != nil {
+			return fmt.Errorf("error in mappings of index %s: %s", index, err.Error())
+		}
+		body := map[string]interface{}{"mappings": adapted}
+		if s := saved.forIndex(index); len(s) > 0 {
+			body["settings"] = s
+		}
+		if _, err = dst.CreateIndex(target).BodyJson(body).Do(ctx); err != nil {
+			return fmt.Errorf("error creating index %s: %s", target, err.Error())
+		}
+	}
+	return nil
+}
+
+// reindexIndex copies an index with a reindex task of the destination
+// cluster, following its progress until it completes, and runs it again
+// if it fails. Documents copied by a failed task are copied again, which
+// replaces them. The task is cancelled if the context is.
+func reindexIndex(ctx context.Context, client *elastic.Client, remote *url.URL, index, target string, q elastic.Query) error {
+	body, err := reindexBody(remote, index, target, q)
+	if err != nil {
+		return err
+	}
+	params := url.Values{"wait_for_completion": []string{"false"}}
+	if *reindexThrottle > 0 {
+		params.Set("requests_per_second", fmt.Sprint(*reindexThrottle))
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "POST", Path: "/_reindex", Params: params, Body: body})
+		if err != nil {
+			return reindexError("index "+index, err.Error())
+		}
+		var started struct {
+			Task string `json:"task"`
+		}
+		if err = json.Unmarshal(res.Body, &started); err != nil || started.Task == "" {
+			return fmt.Errorf("error starting the reindex of index %s: unexpected response %s", index, res.Body)
+		}
+		if *debug {
+			logger.Printf("reindex of index %s started as task %s\n", index, started.Task)
+		}
+		done, err := followReindexTask(ctx, client, index, started.Task)
+		if err == nil || ctx.Err() != nil || attempt >= *reindexRetries {
+			return err
+		}
+		// Take back the progress of the failed task, which is made again.
+		bar.Add64(-done)
+		atomic.AddInt64(&docCount, -done)
+		logger.Printf("\n%s, retrying in %s (%d of %d)\n", err.Error(), reindexRetryBackoff, attempt+1, *reindexRetries)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reindexRetryBackoff):
+		}
+	}
+}
+
+// reindexBody returns the request to reindex an index from the remote
+// cluster. The credentials of the source URL and the --source-header
+// headers are given to the destination to connect with.
+func reindexBody(remote *url.URL, index, target string, q elastic.Query) (map[string]interface{}, error) {
+	host := *remote
+	host.User, host.Path, host.RawQuery = nil, "", ""
+	r := map[string]interface{}{
+		"host":            host.String(),
+		"socket_timeout":  fmt.Sprintf("%ds", int((*reindexSocketTime).Seconds())),
+		"connect_timeout": fmt.Sprintf("%ds", int((*reindexConnectTime).Seconds())),
+	}
+	u := *reindexSrcURL
+	if u.User != nil {
+		r["username"] = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			r["password"] = p
+		}
+	}
+	h, err := parseHeaders(*sourceHeaders)
+	if err != nil {
+		return nil, err
+	}
+	if len(h) > 0 {
+		headers := map[string]string{}
+		for name := range h {
+			headers[name] = h.Get(name)
+		}
+		r["headers"] = headers
+	}
+	source := map[string]interface{}{"remote": r, "index": index, "size": *reindexBatchSize}
+	if q != nil {
+		s, err := q.Source()
+		if err != nil {
+			return nil, err
+		}
+		source["query"] = s
+	}
+	return map[string]interface{}{"source": source, "dest": map[string]interface{}{"index": target}}, nil
+}
+
+// followReindexTask polls a reindex task until it completes, adding its
+// progress to the progress bar, and returns the number of documents it
+// copied. It fails if the task does, or if some documents were not copied.
+func followReindexTask(ctx context.Context, client *elastic.Client, index, id string) (int64, error) {
+	var done int64
+	for {
+		select {
+		case <-ctx.Done():
+			cancelReindexTask(client, id)
+			return done, ctx.Err()
+		case <-time.After(*reindexPoll):
+		}
+		res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{Method: "GET", Path: "/_tasks/" + url.PathEscape(id)})
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return done, fmt.Errorf("error getting the status of reindex task %s: %s", id, err.Error())
+		}
+		var task reindexTask
+		if err = json.Unmarshal(res.Body, &task); err != nil {
+			return done, fmt.Errorf("error parsing the status of reindex task %s: %s", id, err.Error())
+		}
+		status := task.Task.Status
+		if task.Completed && task.Error == nil {
+			status = task.Response.reindexStatus
+		}
+		if n := status.done() - done; n > 0 {
+			bar.Add64(n)
+			atomic.AddInt64(&docCount, n)
+			done += n
+		}
+		if !task.Completed {
+			continue
+		}
+		switch {
+		case task.Error != nil:
+			return done, reindexError("index "+index, task.Error.Type+": "+task.Error.Reason)
+		case len(task.Response.Failures) > 0:
+			return done, fmt.Errorf("reindex of index %s failed to copy %d documents, e.g. %s", index, len(task.Response.Failures), task.Response.Failures[0])
+		}
+		return done, nil
+	}
+}
+
+// cancelReindexTask cancels a reindex task when the command is interrupted.
+func cancelReindexTask(client *elastic.Client, id string) {
+	logger.Printf("\ncancelling reindex task %s\n", id)
+	_, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{Method: "POST", Path: "/_tasks/" + url.PathEscape(id) + "/_cancel"})
+	if err != nil {
+		logger.Printf("error cancelling reindex task %s: %s\n", id, err.Error())
+	}
+}
+
+// reindexError returns the error of a reindex, with what to do if the
+// destination rejected the source host.
+func reindexError(what, reason string) error {
+	if strings.Contains(reason, "whitelist") || strings.Contains(reason, "allowlist") {
+		return fmt.Errorf("reindex of %s rejected: %s - add the host of the source to reindex.remote.whitelist on the destination nodes", what, reason)
+	}
+	return fmt.Errorf("reindex of %s failed: %s", what, reason)
+}
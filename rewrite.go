@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var mappingRulesFile = app.Flag("mapping-rules", "YAML or JSON file of rules to rewrite the mappings of the created indices with, after the built-in rules that update the mappings of older versions").ExistingFile()
+
+// mappingRule rewrites the mappings of the fields it matches: the fields
+// whose path matches Field (a dot path whose segments may be wildcards) and
+// whose mapping has the values of Match. It sets and removes attributes of
+// the mappings, or drops the fields.
+type mappingRule struct {
+	Field  string                 `yaml:"field"`
+	Match  map[string]interface{} `yaml:"match"`
+	Set    map[string]interface{} `yaml:"set"`
+	Remove []string               `yaml:"remove"`
+	Drop   bool                   `yaml:"drop"`
+}
+
+// mappingRules are the rules of --mapping-rules.
+var mappingRules []mappingRule
+
+// deprecatedFilters are the names of token filters and tokenizers of older
+// versions, and their current names.
+var deprecatedFilters = map[string]string{"nGram": "ngram", "edgeNGram": "edge_ngram"}
+
+// loadMappingRules reads the rules file, if there is one.
+func loadMappingRules(file string) error {
+	if file == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var rules struct {
+		Rules []mappingRule `yaml:"rules"`
+	}
+	if err = yaml.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("error parsing %s: %s", file, err.Error())
+	}
+	for i, r := range rules.Rules {
+		if r.Field == "" && len(r.Match) == 0 {
+			return fmt.Errorf("rule %d of %s matches every field, it needs a field or match", i+1, file)
+		}
+	}
+	mappingRules = rules.Rules
+	return nil
+}
+
+// rewriteMappings updates typeless mappings of an older version for the
+// current ones, then applies the rules of --mapping-rules:
+//
+//   - string fields become text with a keyword subfield, or keyword if
+//     they were not analyzed
+//   - _all and include_in_all are removed
+func rewriteMappings(mappings map[string]interface{}) {
+	delete(mappings, "_all")
+	if props, ok := mappings["properties"].(map[string]interface{}); ok {
+		rewriteFields(props, "")
+	}
+}
+
+// rewriteFields rewrites the mappings of the fields of an object, and of
+// their objects and subfields.
+func rewriteFields(props map[string]interface{}, prefix string) {
+	for name, v := range props {
+		field, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fieldPath := prefix + name
+		rewriteStringField(field)
+		delete(field, "include_in_all")
+		if inner, ok := field["properties"].(map[string]interface{}); ok {
+			rewriteFields(inner, fieldPath+".")
+		}
+		if sub, ok := field["fields"].(map[string]interface{}); ok {
+			rewriteFields(sub, fieldPath+".")
+		}
+		for _, r := range mappingRules {
+			if !r.matches(fieldPath, field) {
+				continue
+			}
+			if r.Drop {
+				delete(props, name)
+				break
+			}
+			for _, attr := range r.Remove {
+				delete(field, attr)
+			}
+			for attr, value := range r.Set {
+				field[attr] = value
+			}
+		}
+	}
+}
+
+// rewriteStringField changes a string field of the versions before 5.0
+// into a text or keyword field.
+func rewriteStringField(field map[string]interface{}) {
+	if field["type"] != "string" {
+		return
+	}
+	switch field["index"] {
+	case "not_analyzed":
+		field["type"] = "keyword"
+		delete(field, "index")
+		delete(field, "analyzer")
+		delete(field, "search_analyzer")
+	case "no":
+		field["type"] = "keyword"
+		field["index"] = false
+	default:
+		field["type"] = "text"
+		delete(field, "index")
+		if _, ok := field["fields"]; !ok {
+			field["fields"] = map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256}}
+		}
+	}
+}
+
+// matches returns whether the rule applies to the mapping of a field.
+func (r mappingRule) matches(fieldPath string, field map[string]interface{}) bool {
+	if r.Field != "" {
+		ok, _ := path.Match(strings.Replace(r.Field, ".", "/", -1), strings.Replace(fieldPath, ".", "/", -1))
+		if !ok {
+			return false
+		}
+	}
+	for attr, value := range r.Match {
+		if !reflect.DeepEqual(field[attr], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteSettings updates the flat analysis settings of an older version
+// for the current ones: the nGram and edgeNGram token filters and
+// tokenizers are renamed, and the standard token filter, which did
+// nothing, is removed.
+func rewriteSettings(settings map[string]interface{}) {
+	for k, v := range settings {
+		if !strings.HasPrefix(k, "index.analysis.") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(k, ".type"):
+			if name, ok := deprecatedFilters[fmt.Sprint(v)]; ok {
+				settings[k] = name
+			}
+		case strings.HasPrefix(k, "index.analysis.analyzer.") && strings.HasSuffix(k, ".filter"):
+			filters, ok := v.([]interface{})
+			if !ok {
+				continue
+			}
+			kept := []interface{}{}
+			for _, f := range filters {
+				if name, ok := deprecatedFilters[fmt.Sprint(f)]; ok {
+					f = name
+				}
+				if f != "standard" {
+					kept = append(kept, f)
+				}
+			}
+			settings[k] = kept
+		case strings.HasPrefix(k, "index.analysis.analyzer.") && strings.HasSuffix(k, ".tokenizer"):
+			if name, ok := deprecatedFilters[fmt.Sprint(v)]; ok {
+				settings[k] = name
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+)
+
+// indexRouter picks the index each imported document is written to from
+// the document, creating the index with the imported mappings the first
+// time it is seen.
+type indexRouter struct {
+	client *elastic.Client
+	body   map[string]interface{}
+	// seen are the indices written to, with the original index of the first
+	// document written to each.
+	seen map[string]string
+
+	// appending adds to existing indices, instead of failing.
+	appending bool
+
+	// prefix and suffix are added to the name of every routed index.
+	prefix string
+	suffix string
+
+	// bodies are the bodies to create indices with by the original index
+	// name, for a multi-index export. Other indices are created with body.
+	bodies map[string]map[string]interface{}
+
+	// name returns the index for a document, or an empty string to use the
+	// destination index.
+	name func(hit elastic.SearchHit) (string, error)
+}
+
+// router routes documents on import with --index-field or --split-by-time,
+// or is nil.
+var router *indexRouter
+
+// newFieldRouter routes documents to the index named by the prefix and the
+// value of a field.
+func newFieldRouter(client *elastic.Client, field, prefix string) *indexRouter {
+	return &indexRouter{client: client, seen: map[string]string{}, name: func(hit elastic.SearchHit) (string, error) {
+		v := gjson.GetBytes(hit.Source, field).String()
+		if v == "" {
+			return "", nil
+		}
+		return prefix + v, nil
+	}}
+}
+
+// index returns the index to write a document to, or dstIndex if the router
+// does not name one. Without a dstIndex the document goes to its original
+// index, with the prefix and suffix, as if the router had named it.
+func (r *indexRouter) index(ctx context.Context, dstIndex string, hit elastic.SearchHit) (string, error) {
+	index, err := r.name(hit)
+	if err != nil {
+		return "", err
+	}
+	if index == "" {
+		if dstIndex != "" {
+			// dstIndex is created with the import, but it is still one of
+			// the indices written to.
+			if _, ok := r.seen[dstIndex]; !ok {
+				r.seen[dstIndex] = hit.Index
+			}
+			return dstIndex, nil
+		}
+		if hit.Index == "" {
+			return "", fmt.Errorf("document %s has no _index, use --dest-index", hit.Id)
+		}
+		index = hit.Index
+	}
+	index = strings.ToLower(r.prefix + index + r.suffix)
+	if _, ok := r.seen[index]; ok {
+		return index, nil
+	}
+	r.seen[index] = hit.Index
+	body := r.body
+	if b, ok := r.bodies[hit.Index]; ok {
+		body = b
+	}
+	if body == nil {
+		return index, nil
+	}
+	exists, err := r.client.IndexExists(index).Do(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error checking if index %s exists: %s", index, err.Error())
+	}
+	switch {
+	case exists && *importForce:
+		if err = confirm(fmt.Sprintf("Delete index %s and recreate it?", index)); err != nil {
+			return "", err
+		}
+		if _, err = r.client.DeleteIndex(index).Do(ctx); err != nil {
+			return "", fmt.Errorf("error deleting index %s: %s", index, err.Error())
+		}
+		exists = false
+	case exists && !r.appending:
+		return "", fmt.Errorf("index %s exists - use --append to add to it, or --force to replace it", index)
+	}
+	if !exists {
+		if _, err = r.client.CreateIndex(index).BodyJson(body).Do(ctx); err != nil {
+			return "", fmt.Errorf("error creating index %s: %s", index, err.Error())
+		}
+	}
+	return index, nil
+}
+
+// hitIndex returns the index to write a document to, using the router if
+// there is one, and optimizes the index for bulk loading with
+// --optimize-bulk.
+func hitIndex(ctx context.Context, dstIndex string, hit elastic.SearchHit) (string, error) {
+	index := dstIndex
+	if router != nil {
+		var err error
+		if index, err = router.index(ctx, dstIndex, hit); err != nil {
+			return "", err
+		}
+	} else if dstIndex == "" {
+		index = hit.Index
+	}
+	if optimizer != nil {
+		return index, optimizer.optimize(ctx, index)
+	}
+	return index, nil
+}
+
+// newRenameRouter routes documents to their original index, renamed by
+// sed style substitutions (s/regexp/replacement/) applied in order.
+func newRenameRouter(client *elastic.Client, subs []string) (*indexRouter, error) {
+	type substitution struct {
+		re   *regexp.Regexp
+		repl string
+	}
+	var renames []substitution
+	for _, sub := range subs {
+		if len(sub) < 4 || sub[0] != 's' {
+			return nil, fmt.Errorf("invalid rename %s, use s/regexp/replacement/", sub)
+		}
+		parts := strings.Split(sub[2:], sub[1:2])
+		if len(parts) != 3 || parts[2] != "" {
+			return nil, fmt.Errorf("invalid rename %s, use s/regexp/replacement/", sub)
+		}
+		re, err := regexp.Compile(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rename %s: %s", sub, err.Error())
+		}
+		renames = append(renames, substitution{re, parts[1]})
+	}
+	return &indexRouter{client: client, seen: map[string]string{}, name: func(hit elastic.SearchHit) (string, error) {
+		index := hit.Index
+		for _, r := range renames {
+			index = r.re.ReplaceAllString(index, r.repl)
+		}
+		return index, nil
+	}}, nil
+}
+
+// timeIntervals are the default index name patterns for each interval of
+// --split-by-time.
+var timeIntervals = map[string]string{
+	"hourly":  "%Y.%m.%d.%H",
+	"daily":   "%Y.%m.%d",
+	"weekly":  "%G.%V",
+	"monthly": "%Y.%m",
+	"yearly":  "%Y",
+}
+
+// newTimeRouter routes documents to an index named after the time in a
+// field, as described by spec: space or comma separated field=, interval=
+// and pattern= settings. The pattern is a strftime format (%Y, %m, %d, %H,
+// %j, %G and %V), by default dstIndex and the date for the interval.
+func newTimeRouter(client *elastic.Client, spec, dstIndex string) (*indexRouter, error) {
+	settings := map[string]string{"field": "@timestamp", "interval": "daily"}
+	for _, s := range strings.FieldsFunc(spec, func(r rune) bool { return r == ' ' || r == ',' }) {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid setting %s, use field=, interval= or pattern=", s)
+		}
+		settings[kv[0]] = kv[1]
+	}
+	for k := range settings {
+		if k != "field" && k != "interval" && k != "pattern" {
+			return nil, fmt.Errorf("unknown setting %s, use field=, interval= or pattern=", k)
+		}
+	}
+	pattern, ok := settings["pattern"]
+	if !ok && dstIndex == "" {
+		return nil, fmt.Errorf("pattern= is required without --dest-index")
+	}
+	if !ok {
+		p, ok := timeIntervals[settings["interval"]]
+		if !ok {
+			return nil, fmt.Errorf("unknown interval %s, use hourly, daily, weekly, monthly or yearly", settings["interval"])
+		}
+		pattern = dstIndex + "-" + p
+	}
+	field := settings["field"]
+	return &indexRouter{client: client, seen: map[string]string{}, name: func(hit elastic.SearchHit) (string, error) {
+		v := gjson.GetBytes(hit.Source, field)
+		if !v.Exists() {
+			return "", nil
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return "", fmt.Errorf("error parsing %s: %s", field, err.Error())
+		}
+		return strftime(pattern, t), nil
+	}}, nil
+}
+
+// timeLayouts are the date formats parseTime accepts.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006.01.02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses a date string, or a number of milliseconds since the
+// epoch.
+func parseTime(v gjson.Result) (time.Time, error) {
+	if v.Type == gjson.Number {
+		return time.UnixMilli(v.Int()).UTC(), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, v.String()); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", v.String())
+}
+
+// strftime formats t with a strftime pattern.
+func strftime(pattern string, t time.Time) string {
+	year, week := t.ISOWeek()
+	r := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%j", fmt.Sprintf("%03d", t.YearDay()),
+		"%G", fmt.Sprintf("%04d", year),
+		"%V", fmt.Sprintf("%02d", week),
+		"%%", "%",
+	)
+	return r.Replace(pattern)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// Run the jobs in a file
+	runCmd      = app.Command("run", "Run the jobs described in a YAML or JSON file")
+	runJobsFile = runCmd.Flag("jobs", "YAML or JSON file with the jobs to run").Required().ExistingFile()
+	runLogDir   = runCmd.Flag("log-dir", "Directory to write the log of each job to").Default("logs").String()
+	runParallel = runCmd.Flag("parallel", "Run the jobs at the same time instead of one after the other").Bool()
+	runWorkers  = runCmd.Flag("parallel-jobs", "Maximum number of jobs to run at the same time (implies --parallel, 0 for no limit)").Int()
+)
+
+// pipelineJob is a job in a jobs file. The command (export, import, sync,
+// ...) is run with the options as flags: a true boolean is a flag without a
+// value, a list repeats the flag and a map sets the flag once for each
+// key=value pair. Alternatively the full command line can be given in args.
+// A job with indices is run once for each index, with .Index set for the
+// templates in the options or args and source-index defaulting to it.
+type pipelineJob struct {
+	Name    string                 `yaml:"name"`
+	Command string                 `yaml:"command"`
+	Options map[string]interface{} `yaml:"options"`
+	Args    []string               `yaml:"args"`
+	Indices []string               `yaml:"indices"`
+
+	index string
+}
+
+// pipelineFile is the format of the jobs file.
+type pipelineFile struct {
+	Parallel     bool           `yaml:"parallel"`
+	ParallelJobs int            `yaml:"parallel_jobs"`
+	Jobs         []*pipelineJob `yaml:"jobs"`
+}
+
+// jobResult is the outcome of a job run from a jobs file.
+type jobResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+	Log      string
+	Summary  *runSummary
+}
+
+// commandLine returns the command line arguments of the job.
+func (j *pipelineJob) commandLine() ([]string, error) {
+	if len(j.Args) > 0 {
+		return j.Args, nil
+	}
+	if j.Command == "" {
+		return nil, fmt.Errorf("job %s needs a command or args", j.Name)
+	}
+	args := []string{j.Command}
+	if _, ok := j.Options["source-index"]; !ok && j.index != "" {
+		args = append(args, "--source-index="+j.index)
+	}
+	keys := make([]string, 0, len(j.Options))
+	for k := range j.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := j.Options[k].(type) {
+		case bool:
+			if v {
+				args = append(args, "--"+k)
+			} else {
+				args = append(args, "--no-"+k)
+			}
+		case []interface{}:
+			for _, e := range v {
+				args = append(args, fmt.Sprintf("--%s=%v", k, e))
+			}
+		case map[string]interface{}:
+			for mk, mv := range v {
+				args = append(args, fmt.Sprintf("--%s=%s=%v", k, mk, mv))
+			}
+		case nil:
+		default:
+			args = append(args, fmt.Sprintf("--%s=%v", k, v))
+		}
+	}
+	return args, nil
+}
+
+func doRun() error {
+	b, err := ioutil.ReadFile(*runJobsFile)
+	if err != nil {
+		return err
+	}
+	var pf pipelineFile
+	if err = yaml.Unmarshal(b, &pf); err != nil {
+		return fmt.Errorf("error parsing jobs file %s: %s", *runJobsFile, err.Error())
+	}
+	jobs := expandPipelineJobs(pf.Jobs)
+	if len(jobs) == 0 {
+		return fmt.Errorf("no jobs in %s", *runJobsFile)
+	}
+	names := map[string]bool{}
+	for _, job := range jobs {
+		if names[job.Name] {
+			return fmt.Errorf("duplicate job name %s", job.Name)
+		}
+		names[job.Name] = true
+		if _, err := job.commandLine(); err != nil {
+			return err
+		}
+	}
+
+	// The number of jobs to run at the same time.
+	workers := 1
+	if pf.Parallel || *runParallel || pf.ParallelJobs != 0 || *runWorkers != 0 {
+		workers = len(jobs)
+		for _, n := range []int{pf.ParallelJobs, *runWorkers} {
+			if n > 0 && n < workers {
+				workers = n
+			}
+		}
+	}
+	logger.Printf("running %d jobs, %d at a time\n", len(jobs), workers)
+
+	bar = progressbar.NewOptions(len(jobs), progressbar.OptionSetRenderBlankState(true), progressbar.OptionSetWriter(os.Stderr), progressbar.OptionSetDescription("jobs"))
+	results := make([]jobResult, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = runPipelineJob(jobs[i])
+				bar.Add(1)
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	bar.Finish()
+	return reportJobs(results)
+}
+
+// expandPipelineJobs names unnamed jobs and expands jobs with indices into
+// one job per index.
+func expandPipelineJobs(jobs []*pipelineJob) []*pipelineJob {
+	var expanded []*pipelineJob
+	for i, job := range jobs {
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("job-%d", i+1)
+		}
+		if len(job.Indices) == 0 {
+			expanded = append(expanded, job)
+			continue
+		}
+		for _, index := range job.Indices {
+			j := *job
+			j.Name = job.Name + "-" + index
+			j.index = index
+			expanded = append(expanded, &j)
+		}
+	}
+	return expanded
+}
+
+// runPipelineJob runs a single job as a child process.
+func runPipelineJob(job *pipelineJob) jobResult {
+	startTime := time.Now()
+	r := jobResult{Name: job.Name, Log: filepath.Join(*runLogDir, job.Name+".log")}
+	logJob("job %s: started\n", job.Name)
+	args, _ := job.commandLine()
+	r.Err = runJobProcess(context.Background(), args, jobArgs{Name: job.Name, Index: job.index, Now: startTime}, r.Log)
+	r.Duration = time.Since(startTime).Round(time.Second)
+	if b, err := ioutil.ReadFile(summaryPath(r.Log)); err == nil {
+		r.Summary = &runSummary{}
+		json.Unmarshal(b, r.Summary)
+	}
+	if r.Err != nil {
+		logJob("job %s: failed after %s (log: %s)\n", job.Name, r.Duration, r.Log)
+	} else {
+		logJob("job %s: completed in %s\n", job.Name, r.Duration)
+	}
+	return r
+}
+
+// logJob logs a message about a job above the progress bar.
+func logJob(format string, v ...interface{}) {
+	bar.Clear()
+	logger.Printf(format, v...)
+	bar.RenderBlank()
+}
+
+// reportJobs prints the status of each job and returns an error if any of
+// them failed.
+func reportJobs(results []jobResult) error {
+	failed := 0
+	var docs int64
+	logger.Printf("\n%-30s %-10s %-10s %-10s %s\n", "JOB", "STATUS", "DURATION", "DOCUMENTS", "LOG")
+	for _, r := range results {
+		status := "completed"
+		if r.Err != nil {
+			status = "failed"
+			failed++
+		}
+		n := "-"
+		if r.Summary != nil {
+			n = fmt.Sprint(r.Summary.Documents)
+			docs += r.Summary.Documents
+		}
+		logger.Printf("%-30s %-10s %-10s %-10s %s\n", r.Name, status, r.Duration, n, r.Log)
+	}
+	logger.Printf("\n%d jobs, %d failed, %d documents\n", len(results), failed, docs)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, len(results))
+	}
+	return nil
+}
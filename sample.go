@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Random sampling of exports.
+var (
+	exportSample     = exportCmd.Flag("sample", "Export a random sample of this fraction of the documents, e.g. 5% or 0.05").String()
+	exportSampleSeed = exportCmd.Flag("sample-seed", "Seed of the random sample, to export the same sample again (0 for a different sample each time)").Default("0").Int64()
+
+	// Random sampling of an export file
+	sampleCmd     = app.Command("sample", "Write a random sample of the documents of an export file to another, e.g. a slice of production data small enough for a laptop")
+	sampleSrc     = sampleCmd.Arg("source", "Export file to sample").Required().ExistingFile()
+	sampleDst     = sampleCmd.Flag("output", "File to write the sample to (a file with a '.gz' or '.zst' suffix is compressed)").Short('o').Required().String()
+	samplePercent = sampleCmd.Flag("percent", "Percentage of the documents to keep").Required().Float64()
+	sampleSeed    = sampleCmd.Flag("seed", "Seed of the random sample, to take the same sample again (0 for a different sample each time)").Default("0").Int64()
+)
+
+// parseSample returns the fraction of documents to sample, from a
+// percentage (5%) or a fraction (0.05).
+func parseSample(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --sample %s: %s", s, err.Error())
+	}
+	if strings.HasSuffix(s, "%") {
+		f /= 100
+	}
+	if f <= 0 || f > 1 {
+		return 0, fmt.Errorf("invalid --sample %s: must be more than 0 and at most 100%%", s)
+	}
+	return f, nil
+}
+
+// sampleQuery returns a query matching a random fraction of the documents
+// matched by q (or all documents if q is nil). Each document gets a random
+// score between 0 and 1 and only those above 1-fraction are kept. With a
+// seed the scores, and so the sample, are the same on each run.
+func sampleQuery(q elastic.Query, fraction float64, seed int64) elastic.Query {
+	random := elastic.NewRandomFunction()
+	if seed != 0 {
+		random = random.Seed(seed).Field("_seq_no")
+	}
+	fq := elastic.NewFunctionScoreQuery().AddScoreFunc(random).BoostMode("replace").MinScore(1 - fraction)
+	if q != nil {
+		fq = fq.Query(q)
+	}
+	return fq
+}
+
+func doSample() error {
+	if *samplePercent <= 0 || *samplePercent > 100 {
+		return fmt.Errorf("--percent must be more than 0 and at most 100")
+	}
+	if *sampleSrc == *sampleDst {
+		return fmt.Errorf("the source and output are the same file")
+	}
+	seed := *sampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+	fraction := *samplePercent / 100
+
+	in, err := openExportFile(*sampleSrc)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := createExportFile(*sampleDst)
+	if err != nil {
+		return err
+	}
+	var docs, kept int64
+	err = readNDJSON(in, func(line []byte) error {
+		docs++
+		if r.Float64() >= fraction {
+			return nil
+		}
+		kept++
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error sampling %s: %s", *sampleSrc, err.Error())
+	}
+	if err = copyCompanions(*sampleSrc, *sampleDst); err != nil {
+		return err
+	}
+	logger.Printf("kept %d of %d documents of %s in %s\n", kept, docs, *sampleSrc, *sampleDst)
+	return nil
+}
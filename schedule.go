@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	// Run jobs on a schedule
+	scheduleCmd    = app.Command("schedule", "Stay resident and run jobs on a cron schedule")
+	scheduleConfig = scheduleCmd.Flag("config", "JSON file with the jobs to schedule").Required().ExistingFile()
+	scheduleLogDir = scheduleCmd.Flag("log-dir", "Directory to write the log of each job run to").Default("logs").String()
+	scheduleListen = scheduleCmd.Flag("listen", "Address to serve the job status on (e.g. ':8080', empty to disable)").String()
+)
+
+// scheduledJob is a job in the schedule config. Args are the command line
+// arguments of the job (e.g. ["export", "--source-url=...", ...]) and are
+// Go templates, executed with .Now and .Name (see jobArgs), so a nightly job can refer to
+// yesterday's index as `logs-{{ (.Now.AddDate 0 0 -1).Format "2006.01.02" }}`.
+type scheduledJob struct {
+	Name     string   `json:"name"`
+	Schedule string   `json:"schedule"`
+	Args     []string `json:"args"`
+
+	mu     sync.Mutex
+	status jobStatus
+}
+
+// jobStatus is the state of a scheduled job reported by the status
+// endpoint.
+type jobStatus struct {
+	Name       string    `json:"name"`
+	Schedule   string    `json:"schedule"`
+	Running    bool      `json:"running"`
+	Runs       int       `json:"runs"`
+	Failures   int       `json:"failures"`
+	LastStart  time.Time `json:"last_start,omitempty"`
+	LastEnd    time.Time `json:"last_end,omitempty"`
+	LastStatus string    `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	LastLog    string    `json:"last_log,omitempty"`
+	NextRun    time.Time `json:"next_run,omitempty"`
+}
+
+// scheduleConfigFile is the format of the schedule config.
+type scheduleConfigFile struct {
+	Jobs []*scheduledJob `json:"jobs"`
+}
+
+func doSchedule() error {
+	b, err := ioutil.ReadFile(*scheduleConfig)
+	if err != nil {
+		return err
+	}
+	var config scheduleConfigFile
+	if err = json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("error parsing schedule config %s: %s", *scheduleConfig, err.Error())
+	}
+	if len(config.Jobs) == 0 {
+		return fmt.Errorf("no jobs in schedule config %s", *scheduleConfig)
+	}
+
+	c := cron.New()
+	ids := map[*scheduledJob]cron.EntryID{}
+	for _, job := range config.Jobs {
+		if job.Name == "" || len(job.Args) == 0 {
+			return fmt.Errorf("each job needs a name and args")
+		}
+		job := job
+		job.status = jobStatus{Name: job.Name, Schedule: job.Schedule}
+		id, err := c.AddFunc(job.Schedule, func() { runScheduledJob(job) })
+		if err != nil {
+			return fmt.Errorf("invalid schedule %q for job %s: %s", job.Schedule, job.Name, err.Error())
+		}
+		ids[job] = id
+	}
+
+	if *scheduleListen != "" {
+		http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			var statuses []jobStatus
+			for _, job := range config.Jobs {
+				job.mu.Lock()
+				s := job.status
+				job.mu.Unlock()
+				s.NextRun = c.Entry(ids[job]).Next
+				statuses = append(statuses, s)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(statuses)
+		})
+		go func() {
+			logger.Fatal(http.ListenAndServe(*scheduleListen, nil))
+		}()
+	}
+
+	logger.Printf("scheduled %d jobs\n", len(config.Jobs))
+	c.Start()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-ctx.Done()
+	logger.Printf("stopping, waiting for running jobs to finish\n")
+	<-c.Stop().Done()
+	return nil
+}
+
+// runScheduledJob runs a job as a child process of this binary, writing its
+// output to a log file. A run is skipped if the previous one is still
+// running.
+func runScheduledJob(job *scheduledJob) {
+	job.mu.Lock()
+	if job.status.Running {
+		job.mu.Unlock()
+		logger.Printf("job %s: skipping run, previous run still in progress\n", job.Name)
+		return
+	}
+	startTime := time.Now()
+	job.status.Running = true
+	job.status.LastStart = startTime
+	job.mu.Unlock()
+
+	logPath := filepath.Join(*scheduleLogDir, job.Name, startTime.Format("20060102-150405")+".log")
+	err := runJobProcess(context.Background(), job.Args, jobArgs{Name: job.Name, Now: startTime}, logPath)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.Running = false
+	job.status.Runs++
+	job.status.LastEnd = time.Now()
+	job.status.LastLog = logPath
+	job.status.LastStatus = "completed"
+	job.status.LastError = ""
+	if err != nil {
+		job.status.Failures++
+		job.status.LastStatus = "failed"
+		job.status.LastError = err.Error()
+		logger.Printf("job %s failed: %s (log: %s)\n", job.Name, err, logPath)
+		return
+	}
+	logger.Printf("job %s completed in %s\n", job.Name, time.Since(startTime).Round(time.Second))
+}
+
+// jobArgs is the data the job args templates are executed with.
+type jobArgs struct {
+	Name  string
+	Index string
+	Now   time.Time
+}
+
+// expandArgs executes the job args as templates.
+func expandArgs(args []string, data jobArgs) ([]string, error) {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		t, err := template.New(data.Name).Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q: %s", arg, err.Error())
+		}
+		var b bytes.Buffer
+		if err = t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("invalid argument %q: %s", arg, err.Error())
+		}
+		expanded[i] = b.String()
+	}
+	return expanded, nil
+}
+
+// runJobProcess runs this binary with the expanded job args, writing its
+// output to logPath and its summary next to it (see summaryPath). If ctx is
+// cancelled the process is interrupted, and killed if it has not stopped a
+// minute later.
+func runJobProcess(ctx context.Context, args []string, data jobArgs, logPath string) error {
+	args, err := expandArgs(args, data)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = time.Minute
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.Env = append(os.Environ(), summaryFileEnv+"="+summaryPath(logPath))
+	return cmd.Run()
+}
+
+// summaryPath returns the path of the summary of a job run from the path of
+// its log.
+func summaryPath(logPath string) string {
+	return strings.TrimSuffix(logPath, ".log") + ".summary.json"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+)
+
+var (
+	transformScript = app.Flag("transform-script", "Starlark file defining transform(doc), called with every document (applied after --transform)").ExistingFile()
+)
+
+func init() {
+	registerTransform("script", newScriptTransform)
+}
+
+// newScriptTransform loads a Starlark script that defines a transform
+// function. The function is called with the document as a dict and returns
+// the new document, or None to drop it.
+func newScriptTransform(path string) (transformer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("use script:file.star")
+	}
+	predeclared := starlark.StringDict{"json": json.Module}
+	globals, err := starlark.ExecFile(&starlark.Thread{Name: path}, path, nil, predeclared)
+	if err != nil {
+		return nil, fmt.Errorf("error loading script %s: %s", path, err.Error())
+	}
+	fn, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s does not define a transform function", path)
+	}
+	decode := json.Module.Members["decode"]
+	encode := json.Module.Members["encode"]
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		// A thread is cheap, so each call has its own.
+		thread := &starlark.Thread{Name: path}
+		v, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(doc)}, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		if v, err = starlark.Call(thread, fn, starlark.Tuple{v}, nil); err != nil {
+			return nil, false, err
+		}
+		if v == starlark.None {
+			return nil, false, nil
+		}
+		if v, err = starlark.Call(thread, encode, starlark.Tuple{v}, nil); err != nil {
+			return nil, false, err
+		}
+		return []byte(v.(starlark.String).GoString()), true, nil
+	}), nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// Round trip a small index through an export file
+	selftestCmd   = app.Command("selftest", "Export a small index (generated, or a sample of --index) to a file, import it into a temporary index, verify the copy and clean up, to check the connection to and compatibility with a cluster")
+	selftestURL   = selftestCmd.Flag("url", "Elasticsearch host to test (http://host:port/)").Required().URL()
+	selftestIndex = selftestCmd.Flag("index", "Index to export a sample of, instead of an index generated for the test").String()
+	selftestSize  = selftestCmd.Flag("size", "Number of documents to generate, or to export from --index").Default("100").Int()
+	selftestKeep  = selftestCmd.Flag("keep", "Keep the test indices and export file to look into a failure, instead of deleting them").Bool()
+)
+
+// selftestPrefix is the prefix of the indices created by the self-test.
+const selftestPrefix = "vandelay-selftest-"
+
+func doSelftest() error {
+	if *selftestSize <= 0 {
+		return fmt.Errorf("--size must be positive")
+	}
+	url := (*selftestURL).String()
+	client, info, err := newElasticClient(url, sourceSide)
+	if err != nil {
+		return err
+	}
+	srcCluster, dstCluster = info, info
+	if err = checkDocType(info); err != nil {
+		return err
+	}
+	logger.Printf("self-testing %s %s at %s\n", info.Distribution, info.Version, (*selftestURL).Redacted())
+
+	// The indices created so far, deleted at the end.
+	var created []string
+	defer func() {
+		if *selftestKeep {
+			if len(created) > 0 {
+				logger.Printf("kept the test indices %v\n", created)
+			}
+			return
+		}
+		for _, index := range created {
+			if _, err := client.DeleteIndex(index).Do(context.Background()); err != nil {
+				logger.Printf("warning: error deleting test index %s: %s\n", index, err.Error())
+			}
+		}
+	}()
+	dstIndex := selftestPrefix + time.Now().UTC().Format("20060102150405")
+	srcIndex, filter := *selftestIndex, elastic.Query(nil)
+	if srcIndex == "" {
+		srcIndex = dstIndex + "-source"
+		logger.Printf("generating %d documents in index %s\n", *selftestSize, srcIndex)
+		created = append(created, srcIndex)
+		if err = generateIndex(client, srcIndex, *selftestSize); err != nil {
+			return err
+		}
+	} else if srcIndex, filter, err = resolveIndices(rootCtx, client, srcIndex); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "vandelay-selftest")
+	if err != nil {
+		return err
+	}
+	if *selftestKeep {
+		defer logger.Printf("kept the export file in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	file := filepath.Join(dir, "selftest.json")
+	count := client.Count(srcIndex)
+	if filter != nil {
+		count.Query(filter)
+	}
+	total, err := count.Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error counting documents in index %s: %s", srcIndex, err.Error())
+	}
+	opts := exportOptions{srcURL: url, index: srcIndex, query: filter, filter: filter, total: total, dstFile: file, maxDocs: int64(*selftestSize)}
+	if err = exportData(client, opts); err != nil {
+		return fmt.Errorf("export: %s", err.Error())
+	}
+
+	mappings, err := readMappingsFromFile(file)
+	if err != nil {
+		return err
+	}
+	logger.Printf("importing to index %s\n", dstIndex)
+	if err = writeMappingsAsStringToElastic(client, url, dstIndex, string(mappings), nil); err != nil {
+		return fmt.Errorf("error creating index %s: %s", dstIndex, err.Error())
+	}
+	created = append(created, dstIndex)
+	if err = selftestImport(client, file, dstIndex); err != nil {
+		return fmt.Errorf("import: %s", err.Error())
+	}
+	if _, err = client.Refresh(dstIndex).Do(rootCtx); err != nil {
+		return fmt.Errorf("error refreshing index %s: %s", dstIndex, err.Error())
+	}
+	return selftestVerify(client, file, srcIndex, dstIndex)
+}
+
+// generateIndex creates an index with a field of each common type, and
+// indexes n documents with made up values in it, including non-ASCII text,
+// arrays, nested objects and geo points.
+func generateIndex(client *elastic.Client, index string, n int) error {
+	mappings := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]interface{}{"type": "date"},
+			"name":       map[string]interface{}{"type": "keyword"},
+			"message":    map[string]interface{}{"type": "text"},
+			"count":      map[string]interface{}{"type": "long"},
+			"price":      map[string]interface{}{"type": "double"},
+			"active":     map[string]interface{}{"type": "boolean"},
+			"tags":       map[string]interface{}{"type": "keyword"},
+			"location":   map[string]interface{}{"type": "geo_point"},
+			"client": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"ip":   map[string]interface{}{"type": "ip"},
+					"user": map[string]interface{}{"type": "keyword"},
+				},
+			},
+		},
+	}
+	adapted, err := adaptMappings(mappings, dstCluster)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{"mappings": adapted}
+	if _, err := client.CreateIndex(index).BodyJson(body).Do(rootCtx); err != nil {
+		return fmt.Errorf("error creating index %s: %s", index, err.Error())
+	}
+
+	// A fixed seed, so a failure can be reproduced.
+	r := rand.New(rand.NewSource(1))
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	words := []string{"alpha", "bravo", "charlie", "délta", "écho", "日本語", "emoji 🚀", "quote \"x\"", "back\\slash", "new\nline"}
+	hits := make(chan interface{})
+	g, ctx := errgroup.WithContext(rootCtx)
+	bar = progressbar.NewOptions64(-1, progressbar.OptionSetWriter(io.Discard))
+	if err := writeDataToElastic(ctx, g, client, index, "", hits); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		defer close(hits)
+		for i := 0; i < n; i++ {
+			doc := map[string]interface{}{
+				"@timestamp": start.Add(time.Duration(r.Int63n(int64(365 * 24 * time.Hour)))).Format(time.RFC3339Nano),
+				"name":       "doc-" + strconv.Itoa(i),
+				"message":    words[r.Intn(len(words))] + " " + words[r.Intn(len(words))],
+				"count":      r.Int63n(1 << 50),
+				"price":      float64(r.Intn(100000)) / 100,
+				"active":     r.Intn(2) == 1,
+				"tags":       words[:r.Intn(4)],
+				"location":   map[string]interface{}{"lat": float64(r.Intn(18000)-9000) / 100, "lon": float64(r.Intn(36000)-18000) / 100},
+				"client": map[string]interface{}{
+					"ip":   fmt.Sprintf("10.%d.%d.%d", r.Intn(256), r.Intn(256), r.Intn(256)),
+					"user": fmt.Sprintf("user-%d", r.Intn(10)),
+				},
+			}
+			source, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			select {
+			case hits <- elastic.SearchHit{Index: index, Id: strconv.Itoa(i), Source: source}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return fmt.Errorf("error indexing the generated documents: %s", err.Error())
+	}
+	if _, err := client.Refresh(index).Do(rootCtx); err != nil {
+		return fmt.Errorf("error refreshing index %s: %s", index, err.Error())
+	}
+	return nil
+}
+
+// selftestImport bulk indexes the documents of an export file into an
+// index, the way import does.
+func selftestImport(client *elastic.Client, file, index string) error {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	bar = progressbar.NewOptions64(stat.Size(), progressbar.OptionSetRenderBlankState(true), progressbar.OptionSetWriter(os.Stderr))
+	hits := make(chan interface{})
+	g, ctx := errgroup.WithContext(rootCtx)
+	if err = readDataFromFile(ctx, g, file, hits); err != nil {
+		return err
+	}
+	if err = writeDataToElastic(ctx, g, client, index, "", hits); err != nil {
+		return err
+	}
+	if err = g.Wait(); err != nil {
+		return err
+	}
+	bar.Finish()
+	logger.Printf("\n")
+	return nil
+}
+
+// selftestVerify checks that the imported index has the documents of the
+// export file, with the same _source, and the fields of the source index.
+func selftestVerify(client *elastic.Client, file, srcIndex, dstIndex string) error {
+	var sample []elastic.SearchHit
+	hits := make(chan interface{})
+	g, ctx := errgroup.WithContext(rootCtx)
+	if err := readDataFromFile(ctx, g, file, hits); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		for h := range hits {
+			line := h.([]byte)
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			hit, err := parseHit(line, "")
+			if err != nil {
+				return err
+			}
+			hit.Index = dstIndex
+			hit.Source = append(json.RawMessage(nil), hit.Source...)
+			sample = append(sample, hit)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	failed := 0
+	n, err := client.Count(dstIndex).Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error counting documents in index %s: %s", dstIndex, err.Error())
+	}
+	if n != int64(len(sample)) {
+		logger.Printf("count: %d documents exported, %d imported\n", len(sample), n)
+		failed++
+	} else {
+		logger.Printf("count: %d documents\n", n)
+	}
+	diffs, err := diffMappings(client, srcIndex, client, dstIndex)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		logger.Printf("mapping: %s\n", d)
+	}
+	if len(diffs) == 0 {
+		logger.Printf("mappings: the same\n")
+	}
+	failed += len(diffs)
+	mismatched, err := verifySample(rootCtx, client, sample)
+	if err != nil {
+		return err
+	}
+	logger.Printf("documents: %d of %d match\n", len(sample)-mismatched, len(sample))
+	if failed > 0 || mismatched > 0 {
+		return fmt.Errorf("the copy differs from the original")
+	}
+	logger.Printf("self-test passed\n")
+	return nil
+}
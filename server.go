@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// Manage jobs over HTTP
+	serverCmd      = app.Command("server", "Serve an HTTP API to run and manage export and import jobs")
+	serverListen   = serverCmd.Flag("listen", "Address to listen on, other than loopback only with --token").Default("127.0.0.1:8080").String()
+	serverStateDir = serverCmd.Flag("state-dir", "Directory to keep the job state and logs in").Default("vandelay-server").String()
+	serverToken    = serverCmd.Flag("token", "Bearer token required on every request (required unless listening on loopback)").Envar("VANDELAY_SERVER_TOKEN").String()
+)
+
+// Job states.
+const (
+	jobRunning     = "running"
+	jobCompleted   = "completed"
+	jobFailed      = "failed"
+	jobCancelled   = "cancelled"
+	jobInterrupted = "interrupted"
+)
+
+// serverJob is a job submitted to the server. Args are the command line
+// arguments of the job, as for scheduled jobs, with the passwords hidden;
+// args are the ones it runs with.
+type serverJob struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name,omitempty"`
+	Args    []string   `json:"args"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+	Created time.Time  `json:"created"`
+	Ended   *time.Time `json:"ended,omitempty"`
+
+	args   []string
+	cancel context.CancelFunc
+}
+
+// jobServer runs jobs and keeps their state in stateDir.
+type jobServer struct {
+	stateDir string
+
+	mu   sync.Mutex
+	jobs map[string]*serverJob
+	wg   sync.WaitGroup
+}
+
+func doServer() error {
+	if *serverToken == "" && !isLoopback(*serverListen) {
+		return fmt.Errorf("--token is required to listen on %s, anyone who can reach it could run jobs", *serverListen)
+	}
+	s := &jobServer{stateDir: *serverStateDir, jobs: map[string]*serverJob{}}
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", s.listJobs)
+	mux.HandleFunc("POST /jobs", s.createJob)
+	mux.HandleFunc("GET /jobs/{id}", s.getJob)
+	mux.HandleFunc("GET /jobs/{id}/log", s.getJobLog)
+	mux.HandleFunc("DELETE /jobs/{id}", s.cancelJob)
+	srv := &http.Server{Addr: *serverListen, Handler: s.authorize(mux)}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	logger.Printf("serving job api on %s\n", *serverListen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	// Interrupt any running jobs, they are marked as interrupted.
+	logger.Printf("stopping, interrupting running jobs\n")
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		if job.cancel != nil {
+			job.cancel()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// load reads the saved job state. Jobs that were running when the server
+// stopped are marked as interrupted.
+func (s *jobServer) load() error {
+	if err := os.MkdirAll(filepath.Join(s.stateDir, "logs"), 0755); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.stateDir, "jobs.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var jobs []*serverJob
+	if err = json.Unmarshal(b, &jobs); err != nil {
+		return fmt.Errorf("error parsing job state: %s", err.Error())
+	}
+	for _, job := range jobs {
+		if job.Status == jobRunning {
+			job.Status = jobInterrupted
+		}
+		// The state of older versions has the args in clear.
+		job.Args = redactArgList(job.Args)
+		s.jobs[job.ID] = job
+	}
+	return s.save()
+}
+
+// save writes the job state. The caller must hold s.mu, except while
+// loading.
+func (s *jobServer) save() error {
+	jobs := s.sortedJobs()
+	b, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	f := filepath.Join(s.stateDir, "jobs.json")
+	if err = ioutil.WriteFile(f+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(f+".tmp", f)
+}
+
+// sortedJobs returns the jobs, oldest first. The caller must hold s.mu.
+func (s *jobServer) sortedJobs() []*serverJob {
+	jobs := make([]*serverJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs
+}
+
+func (s *jobServer) logPath(id string) string {
+	return filepath.Join(s.stateDir, "logs", id+".log")
+}
+
+// isLoopback reports whether the listen address only accepts connections
+// from the host itself.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorize checks the bearer token, if one is configured.
+func (s *jobServer) authorize(next http.Handler) http.Handler {
+	want := []byte("Bearer " + *serverToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *serverToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *jobServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.sortedJobs())
+}
+
+func (s *jobServer) getJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *jobServer) getJobLog(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, ok := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	http.ServeFile(w, r, s.logPath(r.PathValue("id")))
+}
+
+// createJob starts a job from a JSON body with the args (and an optional
+// name) and returns the job.
+func (s *jobServer) createJob(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string   `json:"name"`
+		Args []string `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Args) == 0 {
+		http.Error(w, "request body must be a JSON object with args", http.StatusBadRequest)
+		return
+	}
+	if err := checkJobArgs(req.Args); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &serverJob{
+		ID:      hex.EncodeToString(b),
+		Name:    req.Name,
+		Args:    redactArgList(req.Args),
+		Status:  jobRunning,
+		Created: time.Now(),
+		args:    req.Args,
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	err := s.save()
+	s.mu.Unlock()
+	if err != nil {
+		cancel()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.wg.Add(1)
+	go s.runJob(ctx, job)
+	writeJSON(w, http.StatusCreated, job)
+}
+
+// checkJobArgs fails unless the args run an export or an import, whatever
+// global flags come before the command.
+func checkJobArgs(args []string) error {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return fmt.Errorf("invalid args: %s", err.Error())
+	}
+	if ctx.SelectedCommand == nil {
+		return fmt.Errorf("args must have a command")
+	}
+	switch ctx.SelectedCommand.FullCommand() {
+	case "export", "import":
+		return nil
+	}
+	return fmt.Errorf("only export and import jobs can be run, not %s", ctx.SelectedCommand.FullCommand())
+}
+
+// runJob runs a job to completion and records the result.
+func (s *jobServer) runJob(ctx context.Context, job *serverJob) {
+	defer s.wg.Done()
+	err := runJobProcess(ctx, job.args, jobArgs{Name: job.ID, Now: job.Created}, s.logPath(job.ID))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ended := time.Now()
+	job.Ended = &ended
+	job.cancel = nil
+	switch {
+	case job.Status == jobCancelled:
+	case ctx.Err() != nil:
+		job.Status = jobInterrupted
+	case err != nil:
+		job.Status = jobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = jobCompleted
+	}
+	if err := s.save(); err != nil {
+		logger.Printf("error saving job state: %s\n", err)
+	}
+}
+
+// cancelJob interrupts a running job.
+func (s *jobServer) cancelJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if job.cancel == nil {
+		http.Error(w, "job is not running", http.StatusConflict)
+		return
+	}
+	job.Status = jobCancelled
+	job.cancel()
+	writeJSON(w, http.StatusOK, job)
+}
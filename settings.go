@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	importSettingsFile    = importCmd.Flag("settings-file", "JSON file of index settings to create the indices with, merged over the settings saved with the export (e.g. {\"index.number_of_replicas\": 0})").ExistingFile()
+	importReplaceSettings = importCmd.Flag("replace-settings", "Create the indices with the settings of --settings-file only, instead of merging them over the saved ones").Bool()
+)
+
+// notImportedSettings are saved settings that are not given to the indices
+// of an import: ILM policies and pipelines are attached as set by --ilm and
+// --pipelines, and the shard allocation and blocks depend on the source
+// cluster.
+var notImportedSettings = []string{"index.lifecycle.", "index.default_pipeline", "index.final_pipeline", "index.routing.", "index.blocks."}
+
+// importSettings are the settings to create the indices of an import
+// with: the ones saved with the export, by index, and --settings-file.
+type importSettings struct {
+	saved    map[string]map[string]interface{}
+	override map[string]interface{}
+}
+
+// exportSettings saves the settings of the indices next to the export.
+func exportSettings(client *elastic.Client, index, file string) error {
+	res, err := client.IndexGetSettings(index).FlatSettings(true).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error getting settings of index %s: %s", index, err.Error())
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(companionFile(file, "settings"), b, 0644)
+}
+
+// readImportSettings reads the settings saved with the export of src and
+// --settings-file.
+func readImportSettings(src string) (*importSettings, error) {
+	s := &importSettings{}
+	if !*importReplaceSettings {
+		saved, err := readSettingsFromFile(src)
+		if err != nil {
+			return nil, err
+		}
+		s.saved = saved
+	}
+	if *importSettingsFile != "" {
+		b, err := ioutil.ReadFile(*importSettingsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading settings file: %s", err.Error())
+		}
+		r := gjson.ParseBytes(b)
+		if r.Get("settings").IsObject() {
+			r = r.Get("settings")
+		}
+		m, ok := r.Value().(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid settings file %s", *importSettingsFile)
+		}
+		s.override = map[string]interface{}{}
+		flattenSettings("", m, s.override)
+	}
+	return s, nil
+}
+
+// flattenSettings adds nested settings to out with flat names, all in the
+// index. namespace, so that number_of_shards and index.number_of_shards
+// are the same setting.
+func flattenSettings(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenSettings(prefix+k+".", nested, out)
+			continue
+		}
+		name := prefix + k
+		if !strings.HasPrefix(name, "index.") {
+			name = "index." + name
+		}
+		out[name] = v
+	}
+}
+
+// forIndex returns the settings to create the index of an import of the
+// exported index with.
+func (s *importSettings) forIndex(index string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range creatableSettings(s.saved[index]) {
+		imported := true
+		for _, prefix := range notImportedSettings {
+			if strings.HasPrefix(k, prefix) {
+				imported = false
+			}
+		}
+		if imported {
+			out[k] = v
+		}
+	}
+	for k, v := range s.override {
+		out[k] = v
+	}
+	rewriteSettings(out)
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+)
+
+// readDataPerShard exports each shard of the indices with its own scroll,
+// concurrency shards at a time, and sends each hit to the channel. Indices
+// with fewer shards than the others are skipped for the shards they lack.
+// The search preference, if set, chooses the copy of each shard.
+func readDataPerShard(ctx context.Context, srcIndex string, q elastic.Query, preference string, concurrency int, g *errgroup.Group, client *elastic.Client, hits chan interface{}) {
+	g.Go(func() error {
+		defer close(hits)
+		shards, err := maxShards(ctx, client, srcIndex)
+		if err != nil {
+			return err
+		}
+		if *debug {
+			logger.Printf("exporting %d shards, %d at a time\n", shards, concurrency)
+		}
+		sg, sctx := errgroup.WithContext(ctx)
+		sg.SetLimit(concurrency)
+		for shard := 0; shard < shards; shard++ {
+			p := "_shards:" + strconv.Itoa(shard)
+			if preference != "" {
+				p += "|" + preference
+			}
+			sg.Go(func() error {
+				if err := scrollIndex(sctx, client, srcIndex, q, p, hits); err != nil && err != errMaxDocs {
+					return fmt.Errorf("error exporting shard %d: %s", shard, err.Error())
+				}
+				return nil
+			})
+		}
+		if err := sg.Wait(); err != errMaxDocs {
+			return err
+		}
+		return nil
+	})
+}
+
+// maxShards returns the largest number of primary shards of the indices.
+func maxShards(ctx context.Context, client *elastic.Client, index string) (int, error) {
+	res, err := client.IndexGetSettings(index).Name("index.number_of_shards").FlatSettings(true).Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error getting the number of shards of index %s: %s", index, err.Error())
+	}
+	shards := 0
+	for _, s := range res {
+		n, _ := strconv.Atoi(fmt.Sprint(s.Settings["index.number_of_shards"]))
+		if n > shards {
+			shards = n
+		}
+	}
+	if shards == 0 {
+		return 0, fmt.Errorf("no shards found for index %s", index)
+	}
+	return shards, nil
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/schollz/progressbar/v3"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	// Take a snapshot of indices
+	snapshotCmd     = app.Command("snapshot", "Take a snapshot of indices in a snapshot repository, registering the repository first if its type is given, and wait for it to complete")
+	snapshotURL     = snapshotCmd.Flag("url", "Elasticsearch host (http://host:port/)").Required().URL()
+	snapshotIndices = snapshotCmd.Flag("indices", "Indices, patterns or aliases to snapshot, comma separated (default: all)").String()
+	snapshotName    = snapshotCmd.Flag("snapshot", "Name of the snapshot (default: vandelay- and the time)").String()
+	snapshotGlobal  = snapshotCmd.Flag("include-global-state", "Include the cluster state (templates, ILM policies, pipelines, ...) in the snapshot").Bool()
+	snapshotPartial = snapshotCmd.Flag("partial", "Take the snapshot even if some primary shards are unavailable, rather than failing").Bool()
+	snapshotPoll    = snapshotCmd.Flag("poll-interval", "How often to check the progress of the snapshot").Default("5s").Duration()
+	snapshotNoWait  = snapshotCmd.Flag("no-wait", "Return once the snapshot is started, without waiting for it to complete").Bool()
+	snapshotRepo    = repositoryFlags(snapshotCmd, false)
+	restoreCmd      = app.Command("restore", "Restore indices from a snapshot, on the cluster it was taken on or another one with access to the repository, and wait for their shards to recover")
+	restoreURL      = restoreCmd.Flag("url", "Elasticsearch host (http://host:port/)").Required().URL()
+	restoreSnapshot = restoreCmd.Flag("snapshot", "Name of the snapshot to restore, or latest for the newest successful one").Required().String()
+	restoreIndices  = restoreCmd.Flag("indices", "Indices or patterns of the snapshot to restore, comma separated (default: all)").String()
+	restoreRename   = restoreCmd.Flag("rename-pattern", "Regular expression of the names of the restored indices to rename, e.g. (.+)").String()
+	restoreRenameTo = restoreCmd.Flag("rename-replacement", "Replacement of --rename-pattern, with $1 for the first group, e.g. restored-$1").String()
+	restoreSettings = restoreCmd.Flag("index-settings", "JSON object of index settings to change on the restored indices, e.g. {\"index.number_of_replicas\": 0}").String()
+	restoreIgnore   = restoreCmd.Flag("ignore-index-settings", "Index settings to reset to their default on the restored indices (repeatable)").Strings()
+	restoreGlobal   = restoreCmd.Flag("include-global-state", "Restore the cluster state of the snapshot too, replacing templates, ILM policies and pipelines of the same name").Bool()
+	restorePartial  = restoreCmd.Flag("partial", "Restore the indices of a partial snapshot, with empty shards for the missing ones").Bool()
+	restorePoll     = restoreCmd.Flag("poll-interval", "How often to check the recovery of the restored shards").Default("5s").Duration()
+	restoreNoWait   = restoreCmd.Flag("no-wait", "Return once the restore is started, without waiting for the shards to recover").Bool()
+	restoreRepo     = repositoryFlags(restoreCmd, true)
+)
+
+// snapshotRepository is a snapshot repository, registered before use if
+// its type is given.
+type snapshotRepository struct {
+	name     *string
+	kind     *string
+	location *string
+	bucket   *string
+	basePath *string
+	settings *map[string]string
+	readonly bool
+}
+
+// repositoryFlags adds the flags of the snapshot repository to a command.
+// The repository is registered read-only to restore, so that clusters do
+// not write to the repository of another.
+func repositoryFlags(cmd *kingpin.CmdClause, readonly bool) *snapshotRepository {
+	return &snapshotRepository{
+		name:     cmd.Flag("repository", "Name of the snapshot repository").Required().String(),
+		kind:     cmd.Flag("repository-type", "Type of the repository, to register it (or update it) before use: a shared file system (in path.repo of every node) or S3").Enum("fs", "s3"),
+		location: cmd.Flag("location", "Directory of an fs repository").String(),
+		bucket:   cmd.Flag("bucket", "Bucket of an s3 repository").String(),
+		basePath: cmd.Flag("base-path", "Path of the repository in the bucket of an s3 repository").String(),
+		settings: cmd.Flag("repository-setting", "Other setting of the repository, e.g. compress=true, client=backups or region=eu-west-1 (name=value, repeatable)").StringMap(),
+		readonly: readonly,
+	}
+}
+
+// register creates or updates the repository on the cluster if its type is
+// given, or checks that it exists.
+func (r *snapshotRepository) register(client *elastic.Client) error {
+	if *r.kind == "" {
+		if _, err := client.SnapshotGetRepository(*r.name).Do(context.Background()); err != nil {
+			return fmt.Errorf("error getting snapshot repository %s (use --repository-type to register it): %s", *r.name, err.Error())
+		}
+		return nil
+	}
+	settings, err := r.repositorySettings()
+	if err != nil {
+		return err
+	}
+	_, err = client.SnapshotCreateRepository(*r.name).Type(*r.kind).Settings(settings).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error registering snapshot repository %s: %s", *r.name, err.Error())
+	}
+	logger.Printf("registered %s repository %s\n", *r.kind, *r.name)
+	return nil
+}
+
+// dryRun prints whether the repository would be registered or updated, and
+// returns whether it exists on the cluster already.
+func (r *snapshotRepository) dryRun(client *elastic.Client) (bool, error) {
+	_, err := client.SnapshotGetRepository(*r.name).Do(context.Background())
+	if err != nil && !elastic.IsNotFound(err) {
+		return false, fmt.Errorf("error getting snapshot repository %s: %s", *r.name, err.Error())
+	}
+	exists := err == nil
+	if *r.kind == "" {
+		if !exists {
+			return false, fmt.Errorf("no snapshot repository %s (use --repository-type to register it)", *r.name)
+		}
+		return true, nil
+	}
+	if _, err = r.repositorySettings(); err != nil {
+		return false, err
+	}
+	verb := "register"
+	if exists {
+		verb = "update"
+	}
+	logger.Printf("dry run: would %s %s repository %s\n", verb, *r.kind, *r.name)
+	return exists, nil
+}
+
+// repositorySettings returns the settings to register the repository with.
+func (r *snapshotRepository) repositorySettings() (map[string]interface{}, error) {
+	settings := map[string]interface{}{}
+	for k, v := range *r.settings {
+		settings[k] = v
+	}
+	switch *r.kind {
+	case "fs":
+		if *r.location == "" {
+			return nil, fmt.Errorf("--location is required with --repository-type=fs")
+		}
+		settings["location"] = *r.location
+	case "s3":
+		if *r.bucket == "" {
+			return nil, fmt.Errorf("--bucket is required with --repository-type=s3")
+		}
+		settings["bucket"] = *r.bucket
+		if *r.basePath != "" {
+			settings["base_path"] = *r.basePath
+		}
+	}
+	if r.readonly {
+		settings["readonly"] = true
+	}
+	return settings, nil
+}
+
+// snapshotInfo is a snapshot, as listed by the snapshot API.
+type snapshotInfo struct {
+	Snapshot  string   `json:"snapshot"`
+	State     string   `json:"state"`
+	Indices   []string `json:"indices"`
+	StartTime int64    `json:"start_time_in_millis"`
+	Reason    string   `json:"reason"`
+	Shards    struct {
+		Total      int `json:"total"`
+		Failed     int `json:"failed"`
+		Successful int `json:"successful"`
+	} `json:"shards"`
+	Failures []json.RawMessage `json:"failures"`
+}
+
+func doSnapshot() error {
+	client, info, err := newElasticClient((*snapshotURL).String(), sourceSide)
+	if err != nil {
+		return err
+	}
+	srcCluster = info
+	name := *snapshotName
+	if name == "" {
+		name = "vandelay-" + time.Now().UTC().Format("2006.01.02-15.04.05")
+	}
+	if *dryRun {
+		return dryRunSnapshot(client, name)
+	}
+	if err = snapshotRepo.register(client); err != nil {
+		return err
+	}
+	body := map[string]interface{}{"include_global_state": *snapshotGlobal, "partial": *snapshotPartial}
+	if *snapshotIndices != "" {
+		body["indices"] = *snapshotIndices
+	}
+	summary.Source = fmt.Sprintf("%s/%s", strings.TrimSuffix((*snapshotURL).String(), "/"), *snapshotIndices)
+	summary.Destination = *snapshotRepo.name + "/" + name
+	_, err = client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "PUT",
+		Path:   "/_snapshot/" + url.PathEscape(*snapshotRepo.name) + "/" + url.PathEscape(name),
+		Params: url.Values{"wait_for_completion": []string{"false"}},
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("error starting snapshot %s: %s", name, err.Error())
+	}
+	logger.Printf("started snapshot %s in repository %s\n", name, *snapshotRepo.name)
+	if *snapshotNoWait {
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(rootCtx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	startTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			// The snapshot goes on without us.
+			return deadlineError(fmt.Errorf("interrupted, snapshot %s is still running in the cluster", name))
+		case <-time.After(*snapshotPoll):
+		}
+		snap, err := getSnapshot(client, *snapshotRepo.name, name)
+		if err != nil {
+			return err
+		}
+		if snap.Shards.Total > 0 {
+			showShards(snap.Shards.Successful+snap.Shards.Failed, snap.Shards.Total)
+		} else if done, total, err := snapshotShardProgress(client, *snapshotRepo.name, name); err == nil && total > 0 {
+			showShards(done, total)
+		}
+		switch snap.State {
+		case "IN_PROGRESS", "STARTED", "INIT":
+			continue
+		case "SUCCESS":
+			if bar != nil {
+				bar.Finish()
+			}
+			logger.Printf("\nsnapshot %s of %d indices completed in %s\n", name, len(snap.Indices), time.Since(startTime).String())
+			return nil
+		case "PARTIAL":
+			return fmt.Errorf("snapshot %s is partial: %d of %d shards failed", name, snap.Shards.Failed, snap.Shards.Total)
+		default:
+			return fmt.Errorf("snapshot %s %s: %s", name, strings.ToLower(snap.State), snap.Reason)
+		}
+	}
+}
+
+// getSnapshot returns a snapshot of a repository.
+func getSnapshot(client *elastic.Client, repository, name string) (*snapshotInfo, error) {
+	snaps, err := listSnapshots(client, repository, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) == 0 {
+		return nil, fmt.Errorf("no snapshot %s in repository %s", name, repository)
+	}
+	return &snaps[0], nil
+}
+
+// listSnapshots returns the snapshots of a repository matching a name or
+// pattern.
+func listSnapshots(client *elastic.Client, repository, name string) ([]snapshotInfo, error) {
+	res, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshot %s: %s", name, err.Error())
+	}
+	var list struct {
+		Snapshots []snapshotInfo `json:"snapshots"`
+	}
+	if err = json.Unmarshal(res.Body, &list); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot %s: %s", name, err.Error())
+	}
+	return list.Snapshots, nil
+}
+
+// snapshotShardProgress returns the number of shards of a running snapshot
+// that are done, and the total, which the snapshot API only counts once it
+// is complete.
+func snapshotShardProgress(client *elastic.Client, repository, name string) (int, int, error) {
+	res, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_snapshot/" + url.PathEscape(repository) + "/" + url.PathEscape(name) + "/_status",
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var status struct {
+		Snapshots []struct {
+			ShardsStats struct {
+				Done   int `json:"done"`
+				Failed int `json:"failed"`
+				Total  int `json:"total"`
+			} `json:"shards_stats"`
+		} `json:"snapshots"`
+	}
+	if err = json.Unmarshal(res.Body, &status); err != nil || len(status.Snapshots) == 0 {
+		return 0, 0, err
+	}
+	s := status.Snapshots[0].ShardsStats
+	return s.Done + s.Failed, s.Total, nil
+}
+
+func doRestore() error {
+	client, info, err := newElasticClient((*restoreURL).String(), destSide)
+	if err != nil {
+		return err
+	}
+	dstCluster = info
+	if (*restoreRename == "") != (*restoreRenameTo == "") {
+		return fmt.Errorf("--rename-pattern and --rename-replacement go together")
+	}
+	var rename *regexp.Regexp
+	if *restoreRename != "" {
+		if rename, err = regexp.Compile(*restoreRename); err != nil {
+			return fmt.Errorf("invalid --rename-pattern: %s", err.Error())
+		}
+	}
+	var indexSettings map[string]interface{}
+	if *restoreSettings != "" {
+		if err = json.Unmarshal([]byte(*restoreSettings), &indexSettings); err != nil {
+			return fmt.Errorf("invalid --index-settings: %s", err.Error())
+		}
+	}
+	if *dryRun {
+		exists, err := restoreRepo.dryRun(client)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			logger.Printf("dry run: would restore snapshot %s once the repository is registered\n", *restoreSnapshot)
+			return nil
+		}
+	} else if err = restoreRepo.register(client); err != nil {
+		return err
+	}
+	snap, err := findSnapshot(client, *restoreRepo.name, *restoreSnapshot)
+	if err != nil {
+		return err
+	}
+	indices := restoredIndices(snap.Indices, *restoreIndices, rename, *restoreRenameTo)
+	if len(indices) == 0 {
+		return fmt.Errorf("no indices of snapshot %s match %s", snap.Snapshot, *restoreIndices)
+	}
+
+	body := map[string]interface{}{"include_global_state": *restoreGlobal, "partial": *restorePartial}
+	if *restoreIndices != "" {
+		body["indices"] = *restoreIndices
+	}
+	if rename != nil {
+		// Elasticsearch uses Java regular expressions, which mostly agree.
+		body["rename_pattern"] = *restoreRename
+		body["rename_replacement"] = *restoreRenameTo
+	}
+	if indexSettings != nil {
+		body["index_settings"] = indexSettings
+	}
+	if len(*restoreIgnore) > 0 {
+		body["ignore_index_settings"] = *restoreIgnore
+	}
+	summary.Source = *restoreRepo.name + "/" + snap.Snapshot
+	summary.Destination = fmt.Sprintf("%s/%s", strings.TrimSuffix((*restoreURL).String(), "/"), strings.Join(indices, ","))
+	if *dryRun {
+		return dryRunRestore(client, snap, rename)
+	}
+	_, err = client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/_snapshot/" + url.PathEscape(*restoreRepo.name) + "/" + url.PathEscape(snap.Snapshot) + "/_restore",
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("error restoring snapshot %s: %s (restore an index under another name with --rename-pattern, or delete or close the existing one)", snap.Snapshot, err.Error())
+	}
+	logger.Printf("restoring %s from snapshot %s\n", strings.Join(indices, ", "), snap.Snapshot)
+	if *restoreNoWait {
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(rootCtx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	startTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return deadlineError(fmt.Errorf("interrupted, the restored shards are still recovering in the cluster"))
+		case <-time.After(*restorePoll):
+		}
+		done, total, err := recoveredShards(client, indices)
+		if err != nil {
+			return err
+		}
+		if total > 0 {
+			showShards(done, total)
+		}
+		if total > 0 && done == total {
+			bar.Finish()
+			logger.Printf("\nrestore of %d indices completed in %s\n", len(indices), time.Since(startTime).String())
+			return nil
+		}
+	}
+}
+
+// showShards shows the number of shards done on the progress bar, which is
+// made once their number is known, or changes.
+func showShards(done, total int) {
+	if bar == nil || bar.GetMax() != total {
+		bar = progressbar.NewOptions(total, progressbar.OptionSetRenderBlankState(true), progressbar.OptionSetWriter(os.Stderr), progressbar.OptionSetDescription("shards"))
+	}
+	bar.Set(done)
+}
+
+// findSnapshot returns the snapshot to restore: the named one, or the
+// newest successful one for latest.
+func findSnapshot(client *elastic.Client, repository, name string) (*snapshotInfo, error) {
+	if name != "latest" {
+		return getSnapshot(client, repository, name)
+	}
+	snaps, err := listSnapshots(client, repository, "_all")
+	if err != nil {
+		return nil, err
+	}
+	var latest *snapshotInfo
+	for i, s := range snaps {
+		if s.State == "SUCCESS" && (latest == nil || s.StartTime > latest.StartTime) {
+			latest = &snaps[i]
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no successful snapshot in repository %s", repository)
+	}
+	logger.Printf("latest snapshot is %s\n", latest.Snapshot)
+	return latest, nil
+}
+
+// restoredIndices returns the names the indices of a snapshot matching the
+// patterns (all if empty) are restored under.
+func restoredIndices(snapshot []string, patterns string, rename *regexp.Regexp, replacement string) []string {
+	var out []string
+	for _, index := range snapshot {
+		if patterns != "" && !matchesAny(index, strings.Split(patterns, ",")) {
+			continue
+		}
+		if rename != nil {
+			index = rename.ReplaceAllString(index, replacement)
+		}
+		out = append(out, index)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// matchesAny returns whether an index matches one of the patterns, of which
+// those starting with - exclude.
+func matchesAny(index string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "-") {
+			if ok, _ := path.Match(p[1:], index); ok {
+				matched = false
+			}
+		} else if ok, _ := path.Match(p, index); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// recoveredShards returns the number of shards of the indices that are
+// recovered, and their total, from the recovery API.
+func recoveredShards(client *elastic.Client, indices []string) (int, int, error) {
+	res, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/" + strings.Join(indices, ",") + "/_recovery",
+	})
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			// The indices are not created yet.
+			return 0, 0, nil
+		}
+		return 0, 0, fmt.Errorf("error getting the recovery of the restored indices: %s", err.Error())
+	}
+	var recovery map[string]struct {
+		Shards []struct {
+			Stage string `json:"stage"`
+		} `json:"shards"`
+	}
+	if err = json.Unmarshal(res.Body, &recovery); err != nil {
+		return 0, 0, fmt.Errorf("error parsing the recovery of the restored indices: %s", err.Error())
+	}
+	done, total := 0, 0
+	for _, r := range recovery {
+		for _, s := range r.Shards {
+			total++
+			if s.Stage == "DONE" {
+				done++
+			}
+		}
+	}
+	return done, total, nil
+}
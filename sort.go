@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	exportSort = exportCmd.Flag("sort", "Export the documents sorted by a field, as field:asc or field:desc, for a stable order to diff exports or for time-ordered input (repeatable, the first is the primary sort)").Strings()
+)
+
+// sortOrder is the sort of the export, if set with --sort.
+var sortOrder []interface{}
+
+// parseSort returns the sort of a search from field:asc or field:desc
+// specs. The order defaults to asc.
+func parseSort(specs []string) ([]interface{}, error) {
+	var sort []interface{}
+	for _, spec := range specs {
+		field, order := spec, "asc"
+		if i := strings.LastIndex(spec, ":"); i >= 0 {
+			field, order = spec[:i], spec[i+1:]
+		}
+		if field == "" || order != "asc" && order != "desc" {
+			return nil, fmt.Errorf("invalid --sort %s: use field:asc or field:desc", spec)
+		}
+		sort = append(sort, map[string]interface{}{field: map[string]string{"order": order}})
+	}
+	return sort, nil
+}
+
+// searchSort returns the sort of the searches of the export: the --sort
+// fields followed by tiebreak, or only tiebreak if --sort is not set.
+func searchSort(tiebreak string) []interface{} {
+	sort := append([]interface{}{}, sortOrder...)
+	if tiebreak != "" {
+		sort = append(sort, tiebreak)
+	}
+	return sort
+}
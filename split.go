@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// Split an export file into smaller ones
+	splitCmd     = app.Command("split", "Split an export file into numbered chunks, each with a copy of the mappings, e.g. to import them in parallel or store them where objects have a size limit")
+	splitSrc     = splitCmd.Arg("source", "Export file to split").Required().ExistingFile()
+	splitMaxSize = splitCmd.Flag("max-size", "Maximum size of the documents of each chunk before compression (e.g. 5GB)").Bytes()
+	splitMaxDocs = splitCmd.Flag("max-docs", "Maximum number of documents of each chunk").Int64()
+	splitDstDir  = splitCmd.Flag("dest-dir", "Directory to write the chunks to (default: the directory of the source)").ExistingDir()
+)
+
+func doSplit() error {
+	if *splitMaxSize <= 0 && *splitMaxDocs <= 0 {
+		return fmt.Errorf("--max-size or --max-docs is required")
+	}
+	in, err := openExportFile(*splitSrc)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var out *compressedFile
+	var chunks []string
+	var size, docs int64
+	err = readNDJSON(in, func(line []byte) error {
+		n := int64(len(line)) + 1
+		full := *splitMaxSize > 0 && size+n > int64(*splitMaxSize) || *splitMaxDocs > 0 && docs == *splitMaxDocs
+		if out != nil && full {
+			if err := out.Close(); err != nil {
+				return err
+			}
+			out = nil
+		}
+		if out == nil {
+			name := chunkName(*splitSrc, *splitDstDir, len(chunks)+1)
+			var err error
+			if out, err = createExportFile(name); err != nil {
+				return err
+			}
+			chunks = append(chunks, name)
+			size, docs = 0, 0
+		}
+		size += n
+		docs++
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+		return out.WriteByte('\n')
+	})
+	if out != nil {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error splitting %s: %s", *splitSrc, err.Error())
+	}
+	for _, chunk := range chunks {
+		if err = copyCompanions(*splitSrc, chunk); err != nil {
+			return err
+		}
+	}
+	logger.Printf("split %s into %d files: %s\n", *splitSrc, len(chunks), strings.Join(chunks, ", "))
+	return nil
+}
+
+// chunkName returns the name of the i-th chunk of an export file, with its
+// number before the extensions: logs-00001.json.gz for logs.json.gz.
+func chunkName(file, dir string, i int) string {
+	if dir == "" {
+		dir = filepath.Dir(file)
+	}
+	base := filepath.Base(file)
+	ext := base[len(trimCompression(base)):]
+	base = trimCompression(base)
+	for _, e := range []string{".json", ".ndjson"} {
+		if strings.HasSuffix(base, e) {
+			base, ext = strings.TrimSuffix(base, e), e+ext
+		}
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%05d%s", base, i, ext))
+}
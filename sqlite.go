@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"golang.org/x/sync/errgroup"
+
+	// Pure go sqlite driver, so binaries can still be built with CGO_ENABLED=0.
+	_ "modernc.org/sqlite"
+)
+
+// sqlColumn is a scalar field from the index mappings that is promoted to
+// its own column alongside the JSON document.
+type sqlColumn struct {
+	Name string // the dot path of the field, also used as the column name
+	Type string // the sql column type
+}
+
+// sqliteTypes maps elasticsearch field types to sqlite column types. Fields
+// of any other type are only available in the JSON column.
+var sqliteTypes = map[string]string{
+	"keyword":          "TEXT",
+	"constant_keyword": "TEXT",
+	"wildcard":         "TEXT",
+	"text":             "TEXT",
+	"ip":               "TEXT",
+	"date":             "TEXT",
+	"date_nanos":       "TEXT",
+	"long":             "INTEGER",
+	"integer":          "INTEGER",
+	"short":            "INTEGER",
+	"byte":             "INTEGER",
+	"unsigned_long":    "INTEGER",
+	"boolean":          "INTEGER",
+	"double":           "REAL",
+	"float":            "REAL",
+	"half_float":       "REAL",
+	"scaled_float":     "REAL",
+}
+
+// mappingColumns returns the scalar fields of an index mapping (as returned
+// by readMappingsFromElastic) as columns, using types to map the field type
+// to a column type. Object fields are flattened into dot paths.
+func mappingColumns(m map[string]interface{}, index string, types map[string]string) []sqlColumn {
+	im, _ := m[index].(map[string]interface{})
+	mappings, _ := im["mappings"].(map[string]interface{})
+	props, _ := mappings["properties"].(map[string]interface{})
+	cols := flattenProperties("", props, types)
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Name < cols[j].Name })
+	return cols
+}
+
+// flattenProperties walks mapping properties recursively and returns the
+// fields with a type listed in types.
+func flattenProperties(prefix string, props map[string]interface{}, types map[string]string) (cols []sqlColumn) {
+	for name, v := range props {
+		field, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if sub, ok := field["properties"].(map[string]interface{}); ok {
+			cols = append(cols, flattenProperties(prefix+name+".", sub, types)...)
+			continue
+		}
+		t, _ := field["type"].(string)
+		if colType, ok := types[t]; ok {
+			cols = append(cols, sqlColumn{Name: prefix + name, Type: colType})
+		}
+	}
+	return
+}
+
+// columnValue extracts the value for a promoted column from a document.
+// Arrays and objects are stored as their JSON text.
+func columnValue(doc []byte, path string) interface{} {
+	r := gjson.GetBytes(doc, gjsonEscape(path))
+	switch {
+	case !r.Exists() || r.Type == gjson.Null:
+		return nil
+	case r.IsArray(), r.IsObject():
+		return r.Raw
+	case r.Type == gjson.True:
+		return 1
+	case r.Type == gjson.False:
+		return 0
+	case r.Type == gjson.Number:
+		if strings.ContainsAny(r.Raw, ".eE") {
+			return r.Float()
+		}
+		return r.Int()
+	default:
+		return r.String()
+	}
+}
+
+// gjsonEscape escapes the gjson wildcard characters in a dot path.
+func gjsonEscape(path string) string {
+	r := strings.NewReplacer("*", `\*`, "?", `\?`)
+	return r.Replace(path)
+}
+
+// quoteIdent quotes a sql identifier, such as an index name, which may
+// contain characters like '-' or '.'.
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// sqliteTable is a per-index table that documents are inserted into.
+type sqliteTable struct {
+	insert string
+	cols   []sqlColumn
+}
+
+// writeDataToSQLite writes each document sent on channel into a sqlite
+// database, one table per index. Each table has the _id, the JSON _source
+// and one column per scalar field in the index mappings. The mappings
+// themselves are saved in the _mappings table.
+func writeDataToSQLite(ctx context.Context, g *errgroup.Group, dbPath string, mappings map[string]interface{}, hits chan interface{}) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open sqlite database %s: %s", dbPath, err.Error())
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS _mappings (index_name TEXT PRIMARY KEY, mapping TEXT)`)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("unable to create mappings table in %s: %s", dbPath, err.Error())
+	}
+	for index, m := range mappings {
+		b, _ := json.Marshal(m)
+		_, err = db.Exec(`INSERT OR REPLACE INTO _mappings (index_name, mapping) VALUES (?, ?)`, index, string(b))
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("unable to save mappings for %s: %s", index, err.Error())
+		}
+	}
+
+	g.Go(func() error {
+		defer db.Close()
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		tables := map[string]*sqliteTable{}
+		n := 0
+		for h := range hits {
+			hit, err := toHit(h)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			t, ok := tables[hit.Index]
+			if !ok {
+				t, err = createSQLiteTable(tx, hit.Index, mappingColumns(mappings, hit.Index, sqliteTypes))
+				if err != nil {
+					tx.Rollback()
+					return err
+				}
+				tables[hit.Index] = t
+			}
+			args := []interface{}{hit.Id, string(hit.Source)}
+			for _, c := range t.cols {
+				args = append(args, columnValue(hit.Source, c.Name))
+			}
+			if _, err := tx.Exec(t.insert, args...); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error inserting document %s: %s", hit.Id, err.Error())
+			}
+
+			bar.Add64(1)
+
+			// Commit in batches to keep the transaction small.
+			n++
+			if n%size == 0 {
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				if tx, err = db.Begin(); err != nil {
+					return err
+				}
+			}
+
+			// Terminate early?
+			select {
+			default:
+			case <-ctx.Done():
+				tx.Rollback()
+				return ctx.Err()
+			}
+		}
+		return tx.Commit()
+	})
+	return nil
+}
+
+// createSQLiteTable creates the table for an index and builds the insert
+// statement for it.
+func createSQLiteTable(tx *sql.Tx, index string, cols []sqlColumn) (*sqliteTable, error) {
+	defs := []string{"_id TEXT PRIMARY KEY", "_source TEXT"}
+	names := []string{"_id", "_source"}
+	for _, c := range cols {
+		defs = append(defs, quoteIdent(c.Name)+" "+c.Type)
+		names = append(names, quoteIdent(c.Name))
+	}
+	q := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdent(index), strings.Join(defs, ", "))
+	if _, err := tx.Exec(q); err != nil {
+		return nil, fmt.Errorf("unable to create table for index %s: %s", index, err.Error())
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	q = fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)", quoteIdent(index), strings.Join(names, ", "), placeholders)
+	return &sqliteTable{insert: q, cols: cols}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	// Show the size and layout of an index
+	statsCmd   = app.Command("stats", "Show the number of documents, size, shards and fields of an index, to estimate the size and duration of an export")
+	statsURL   = statsCmd.Flag("url", "Elasticsearch host (http://host:port/)").Required().URL()
+	statsIndex = statsCmd.Flag("index", "Elasticsearch index, alias or pattern").Required().String()
+)
+
+func doStats() error {
+	client, info, err := newElasticClient((*statsURL).String(), sourceSide)
+	if err != nil {
+		return err
+	}
+	srcCluster = info
+	index, _, err := resolveIndices(rootCtx, client, *statsIndex)
+	if err != nil {
+		return err
+	}
+	shards, err := client.CatShards().Index(strings.Split(index, ",")...).Bytes("b").
+		Columns("index", "shard", "prirep", "state", "docs", "store", "node").Do(rootCtx)
+	if err != nil {
+		return fmt.Errorf("error getting the shards of index %s: %s", *statsIndex, err.Error())
+	}
+	mappings, err := readMappingsFromElastic(client, index)
+	if err != nil {
+		return fmt.Errorf("error getting the mappings of index %s: %s", *statsIndex, err.Error())
+	}
+
+	var primaries []int
+	var docs, priSize, totalSize int64
+	replicas := 0
+	for i, s := range shards {
+		size, _ := strconv.ParseInt(s.Store, 10, 64)
+		totalSize += size
+		if s.Prirep != "p" && s.Prirep != "primary" {
+			replicas++
+			continue
+		}
+		primaries = append(primaries, i)
+		docs += s.Docs
+		priSize += size
+	}
+	fields := map[string]string{}
+	for _, m := range mappings {
+		fieldTypes(m.(map[string]interface{})["mappings"], "", fields)
+	}
+	byType := map[string]int{}
+	for _, t := range fields {
+		byType[t]++
+	}
+	names := make([]string, 0, len(byType))
+	for t := range byType {
+		names = append(names, t)
+	}
+	// The most used types first.
+	sort.Slice(names, func(i, j int) bool {
+		a, b := byType[names[i]], byType[names[j]]
+		return a > b || a == b && names[i] < names[j]
+	})
+	types := make([]string, len(names))
+	for i, t := range names {
+		types[i] = fmt.Sprintf("%d %s", byType[t], t)
+	}
+
+	indices := strings.Split(index, ",")
+	fmt.Printf("indices:    %s\n", strings.Join(indices, ", "))
+	fmt.Printf("documents:  %d\n", docs)
+	fmt.Printf("size:       %s of primaries, %s with replicas\n", formatBytes(priSize), formatBytes(totalSize))
+	if docs > 0 {
+		fmt.Printf("average:    %s per document\n", formatBytes(priSize/docs))
+	}
+	fmt.Printf("shards:     %d primaries, %d replicas\n", len(primaries), replicas)
+	fmt.Printf("fields:     %d (%s)\n", len(fields), strings.Join(types, ", "))
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tSHARD\tDOCS\tSIZE\tSTATE\tNODE")
+	sort.Slice(primaries, func(i, j int) bool {
+		a, b := shards[primaries[i]], shards[primaries[j]]
+		return a.Index < b.Index || a.Index == b.Index && a.Shard < b.Shard
+	})
+	for _, i := range primaries {
+		s := shards[i]
+		size, _ := strconv.ParseInt(s.Store, 10, 64)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", s.Index, s.Shard, s.Docs, formatBytes(size), s.State, s.Node)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+var (
+	// Incrementally export new documents
+	syncCmd       = app.Command("sync", "Export only the documents newer than the previous sync of an index")
+	syncSrcURL    = syncCmd.Flag("source-url", "Elasticsearch host to export (http://host:port/)").Required().URL()
+	syncSrcIndex  = syncCmd.Flag("source-index", "Elasticsearch index to export").Required().String()
+	syncTimeField = syncCmd.Flag("time-field", "Elasticsearch time field to track the newest exported document with").Required().String()
+	syncTimeFmt   = syncCmd.Flag("time-format", "Elasticsearch date format to keep the newest exported time in, e.g. epoch_millis for millisecond precision").Default(defaultTimeFormat).String()
+	syncStateFile = syncCmd.Flag("state-file", "File to keep the newest exported time in between runs").Required().String()
+	syncDstFile   = syncCmd.Flag("dest-file", "File path to save the new documents to, a Go template with .Now (e.g. 'logs-{{ .Now.Format \"20060102150405\" }}.gz')").String()
+	syncDst       = syncCmd.Flag("dest", "Destination to export to instead of a file (http://host:9200/ for another cluster, sqlite://, postgres://, kafka://, nats:// or amqp://)").String()
+	syncSrcOnly   = syncCmd.Flag("source-only", "Export only the document source of each hit, without the metadata (_id, _index, ...)").Bool()
+)
+
+// syncState is saved in the state file between runs.
+type syncState struct {
+	Index     string `json:"index"`
+	TimeField string `json:"time_field"`
+	Watermark string `json:"watermark"`
+	// TimeFormat is the date format of Watermark, if not the default.
+	TimeFormat string    `json:"time_format,omitempty"`
+	LastRun    time.Time `json:"last_run"`
+	Documents  int64     `json:"documents"`
+}
+
+func doSync() error {
+	if (*syncDstFile == "") == (*syncDst == "") {
+		return fmt.Errorf("exactly one of --dest-file or --dest is required")
+	}
+	state, err := readSyncState(*syncStateFile)
+	if err != nil {
+		return err
+	}
+	if state.Watermark != "" && (state.Index != *syncSrcIndex || state.TimeField != *syncTimeField) {
+		return fmt.Errorf("state file %s is for index %s and time field %s", *syncStateFile, state.Index, state.TimeField)
+	}
+	timeFormat = esTimeFormat(*syncTimeFmt)
+	if state.Watermark != "" && stateTimeFormat(state) != timeFormat {
+		return fmt.Errorf("state file %s keeps the time in format %s, not %s", *syncStateFile, stateTimeFormat(state), timeFormat)
+	}
+
+	srcURL := (*syncSrcURL).String()
+	client, src, err := connectElasticSource(srcURL, *syncSrcIndex, nil)
+	if err != nil {
+		return err
+	}
+
+	// Export up to the newest document now, so documents indexed during
+	// the export are picked up by the next run.
+	q := andQuery(timeQuery(*syncTimeField, state.Watermark, ""), src.filter)
+	end, err := maxTime(client, src.index, *syncTimeField, q)
+	if err != nil {
+		return err
+	}
+	if end == "" {
+		logger.Printf("no documents newer than %s in index %s\n", state.Watermark, *syncSrcIndex)
+		return nil
+	}
+	q = andQuery(timeQuery(*syncTimeField, state.Watermark, end), src.filter)
+	total, err := client.Count(src.index).Query(q).Do(context.Background())
+	if err != nil {
+		return fmt.Errorf("error counting documents in index %s: %s", *syncSrcIndex, err.Error())
+	}
+
+	var dstFile string
+	if *syncDstFile != "" {
+		args, err := expandArgs([]string{*syncDstFile}, jobArgs{Name: "dest-file", Index: *syncSrcIndex, Now: time.Now()})
+		if err != nil {
+			return err
+		}
+		dstFile = args[0]
+		if _, err := os.Stat(dstFile); err == nil && !isFIFO(dstFile) {
+			return fmt.Errorf("destination file %s already exists - use a template in --dest-file to name each sync", dstFile)
+		}
+	}
+	if *dryRun {
+		logger.Printf("dry run: would sync %d documents with %s after %q up to %q\n", total, *syncTimeField, state.Watermark, end)
+		return nil
+	}
+	logger.Printf("syncing documents with %s after %q up to %q\n", *syncTimeField, state.Watermark, end)
+	opts := exportOptions{
+		srcURL:     srcURL,
+		index:      src.index,
+		query:      q,
+		total:      total,
+		dstFile:    dstFile,
+		dst:        *syncDst,
+		sourceOnly: *syncSrcOnly,
+	}
+	if err = exportData(client, opts); err != nil {
+		return err
+	}
+
+	state.Index = *syncSrcIndex
+	state.TimeField = *syncTimeField
+	state.Watermark = end
+	state.TimeFormat = ""
+	if timeFormat != defaultTimeFormat {
+		state.TimeFormat = timeFormat
+	}
+	state.LastRun = time.Now()
+	state.Documents = total
+	return writeSyncState(*syncStateFile, state)
+}
+
+// maxTime returns the newest value of the time field of the documents
+// matching q, in the time filter format, or an empty string if there are no
+// documents.
+func maxTime(client *elastic.Client, index, field string, q elastic.Query) (string, error) {
+	search := client.Search(index).Size(0).
+		Aggregation("max", elastic.NewMaxAggregation().Field(field).Format(timeFormat))
+	if q != nil {
+		search.Query(q)
+	}
+	res, err := search.Do(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("error getting the newest %s in index %s: %s", field, index, err.Error())
+	}
+	agg, found := res.Aggregations.Max("max")
+	if !found || agg.Value == nil {
+		return "", nil
+	}
+	var s string
+	if raw, ok := agg.Aggregations["value_as_string"]; ok {
+		json.Unmarshal(raw, &s)
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// stateTimeFormat returns the date format of the watermark of a state.
+func stateTimeFormat(state *syncState) string {
+	if state.TimeFormat == "" {
+		return defaultTimeFormat
+	}
+	return state.TimeFormat
+}
+
+// readSyncState reads the state file, returning an empty state if it does
+// not exist yet.
+func readSyncState(path string) (*syncState, error) {
+	state := &syncState{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %s", path, err.Error())
+	}
+	return state, nil
+}
+
+// writeSyncState saves the state file, replacing it atomically so an
+// interrupted write does not lose the watermark.
+func writeSyncState(path string, state *syncState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
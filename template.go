@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/tidwall/sjson"
+)
+
+var (
+	transformTemplates = app.Flag("transform-template", "Set _index, _id or a field of every document from a Go template, as field=template (repeatable; e.g. '_index=logs-{{ .Source.host }}', 'env={{ env \"ENV\" }}')").Strings()
+)
+
+// hitTemplate sets the index, id or a source field of a document.
+type hitTemplate struct {
+	field string
+	tmpl  *template.Template
+}
+
+// hitTemplateData is the data the document templates are executed with.
+type hitTemplateData struct {
+	Index  string
+	ID     string
+	Source map[string]interface{}
+}
+
+// hitTemplates are applied to every document after the transforms.
+var hitTemplates []hitTemplate
+
+// addHitTemplates parses the templates described by specs (field=template).
+func addHitTemplates(specs []string) error {
+	funcs := template.FuncMap{
+		"env":   os.Getenv,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid template %s: use field=template", spec)
+		}
+		t, err := template.New(parts[0]).Funcs(funcs).Option("missingkey=zero").Parse(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %s", spec, err.Error())
+		}
+		hitTemplates = append(hitTemplates, hitTemplate{field: parts[0], tmpl: t})
+	}
+	return nil
+}
+
+// applyHitTemplates executes the templates with the index, id and source of
+// a document and returns them with the templated values set.
+func applyHitTemplates(index, id string, src []byte) (string, string, []byte, error) {
+	data := hitTemplateData{Index: index, ID: id}
+	d := json.NewDecoder(bytes.NewReader(src))
+	d.UseNumber()
+	if err := d.Decode(&data.Source); err != nil {
+		return "", "", nil, err
+	}
+	var b bytes.Buffer
+	for _, t := range hitTemplates {
+		b.Reset()
+		if err := t.tmpl.Execute(&b, data); err != nil {
+			return "", "", nil, err
+		}
+		v := strings.ReplaceAll(b.String(), "<no value>", "")
+		var err error
+		switch t.field {
+		case "_index":
+			index = v
+		case "_id":
+			id = v
+		default:
+			src, err = sjson.SetBytes(src, t.field, v)
+		}
+		if err != nil {
+			return "", "", nil, err
+		}
+	}
+	return index, id, src, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Time range filtering of imports, done while reading the documents.
+var (
+	importTimeField = importCmd.Flag("time-field", "Only import the documents with a time field in the range of --time-start and --time-end").String()
+	importTimeStart = importCmd.Flag("time-start", "Only import the documents after this time (YYYY.MM.DD HH:MM:SS, ISO 8601, epoch millis, or date math such as now-1d/d)").String()
+	importTimeEnd   = importCmd.Flag("time-end", "Only import the documents up to this time (YYYY.MM.DD HH:MM:SS, ISO 8601, epoch millis, or date math such as now/d)").String()
+	importTimeZone  = importCmd.Flag("time-zone", "Time zone of --time-start and --time-end, and of rounding in date math, e.g. Europe/Paris, Local or +01:00 (default: UTC)").String()
+)
+
+// parseTimeFlag returns the time of a --time-start or --time-end on
+// import: date math relative to now, a number of milliseconds since the
+// epoch, or a date in one of timeLayouts, in timeZone unless it has an
+// offset. It returns the zero time if s is empty.
+func parseTimeFlag(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if isDateMath(s) {
+		return evalDateMath(s, now)
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, timeZone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", s)
+}
+
+// newTimeRangeTransform keeps the documents with a time field after start
+// and up to end, like the time filter of an export. Either may be the zero
+// time for an open range. Documents without the field, or with a value
+// that is not a date, are dropped.
+func newTimeRangeTransform(field string, start, end time.Time) transformer {
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		v := gjson.GetBytes(doc, field)
+		if !v.Exists() {
+			return nil, false, nil
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			if *debug {
+				logger.Printf("skipping document: error parsing %s: %s\n", field, err.Error())
+			}
+			return nil, false, nil
+		}
+		if !start.IsZero() && !t.After(start) || !end.IsZero() && t.After(end) {
+			return nil, false, nil
+		}
+		return doc, true, nil
+	})
+}
+
+// addTimeRangeTransform filters the imported documents by --time-field,
+// before any other transform.
+func addTimeRangeTransform() error {
+	if *importTimeField == "" {
+		if *importTimeStart != "" || *importTimeEnd != "" {
+			return fmt.Errorf("--time-field is required with --time-start and --time-end")
+		}
+		return nil
+	}
+	loc, err := parseTimeZone(*importTimeZone)
+	if err != nil {
+		return err
+	}
+	timeZone = loc
+	now := time.Now()
+	start, err := parseTimeFlag(*importTimeStart, now)
+	if err != nil {
+		return fmt.Errorf("invalid --time-start: %s", err.Error())
+	}
+	end, err := parseTimeFlag(*importTimeEnd, now)
+	if err != nil {
+		return fmt.Errorf("invalid --time-end: %s", err.Error())
+	}
+	transforms = append(transformChain{newTimeRangeTransform(*importTimeField, start, end)}, transforms...)
+	return nil
+}
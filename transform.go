@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"golang.org/x/sync/errgroup"
+)
+
+// Document transforms, applied on export and import.
+var (
+	dropFields     = app.Flag("drop-field", "Remove a field from every document, a dot path whose segments may be wildcards (e.g. 'user.credentials.*', repeatable)").Strings()
+	transformSpecs = app.Flag("transform", "Transform to apply to every document, as name:argument (repeatable, applied in order; e.g. rename:old=new, drop:path, mask:path, script:file.star)").Strings()
+)
+
+// transformer changes a document (the _source of a hit). It returns the
+// new document, or false if the document should be dropped.
+type transformer interface {
+	Transform(doc []byte) ([]byte, bool, error)
+}
+
+// transformFunc adapts a function to a transformer.
+type transformFunc func(doc []byte) ([]byte, bool, error)
+
+// Transform calls f(doc).
+func (f transformFunc) Transform(doc []byte) ([]byte, bool, error) {
+	return f(doc)
+}
+
+// transformChain applies transformers in order, stopping at the first one
+// that drops the document.
+type transformChain []transformer
+
+// Transform applies each transformer in the chain.
+func (c transformChain) Transform(doc []byte) ([]byte, bool, error) {
+	for _, t := range c {
+		var keep bool
+		var err error
+		doc, keep, err = t.Transform(doc)
+		if err != nil || !keep {
+			return nil, false, err
+		}
+	}
+	return doc, true, nil
+}
+
+// transformFactory creates a transformer from the argument given after the
+// name in --transform name:argument.
+type transformFactory func(arg string) (transformer, error)
+
+// transformRegistry holds the transformers available to --transform.
+var transformRegistry = map[string]transformFactory{}
+
+// registerTransform makes a transformer available to --transform.
+func registerTransform(name string, f transformFactory) {
+	transformRegistry[name] = f
+}
+
+func init() {
+	registerTransform("rename", newRenameTransform)
+	registerTransform("drop", newDropTransform)
+	registerTransform("mask", newMaskTransform)
+	registerTransform("set", newSetTransform)
+}
+
+// transforms is the chain of transformers applied to every document.
+var transforms transformChain
+
+// addTransforms appends the transformers described by specs (name:argument)
+// to the chain.
+func addTransforms(specs []string) error {
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		f, ok := transformRegistry[parts[0]]
+		if !ok {
+			names := make([]string, 0, len(transformRegistry))
+			for name := range transformRegistry {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown transform %s (available: %s)", parts[0], strings.Join(names, ", "))
+		}
+		var arg string
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+		t, err := f(arg)
+		if err != nil {
+			return fmt.Errorf("invalid transform %s: %s", spec, err.Error())
+		}
+		transforms = append(transforms, t)
+	}
+	return nil
+}
+
+// newRenameTransform moves the value at one dot path to another, given as
+// old=new.
+func newRenameTransform(arg string) (transformer, error) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("use rename:old=new")
+	}
+	from, to := parts[0], parts[1]
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		v := gjson.GetBytes(doc, from)
+		if !v.Exists() {
+			return doc, true, nil
+		}
+		doc, err := sjson.DeleteBytes(doc, from)
+		if err == nil {
+			doc, err = sjson.SetRawBytes(doc, to, []byte(v.Raw))
+		}
+		return doc, true, err
+	}), nil
+}
+
+// renameMappingFields renames the fields in an exported mappings file, so
+// renamed fields keep their mapping.
+func renameMappingFields(m []byte, renames []string) ([]byte, error) {
+	var indices []string
+	gjson.ParseBytes(m).ForEach(func(k, v gjson.Result) bool {
+		indices = append(indices, k.String())
+		return true
+	})
+	for _, r := range renames {
+		parts := strings.SplitN(r, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid rename %s: use old=new", r)
+		}
+		for _, index := range indices {
+			prefix := pathEscaper.Replace(index) + ".mappings"
+			from := prefix + mappingPath(parts[0])
+			v := gjson.GetBytes(m, from)
+			if !v.Exists() {
+				continue
+			}
+			var err error
+			if m, err = sjson.DeleteBytes(m, from); err != nil {
+				return nil, err
+			}
+			if m, err = sjson.SetRawBytes(m, prefix+mappingPath(parts[1]), []byte(v.Raw)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// mappingPath returns the path of a field in the mapping properties.
+func mappingPath(field string) string {
+	return ".properties." + strings.Replace(field, ".", ".properties.", -1)
+}
+
+// newDropTransform removes the value at a dot path. A path segment may be
+// a wildcard pattern (e.g. user.credentials.* or *.password).
+func newDropTransform(arg string) (transformer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("use drop:path")
+	}
+	segments := strings.Split(arg, ".")
+	for _, s := range segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s", arg)
+		}
+	}
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		var err error
+		for _, p := range matchPaths(doc, "", segments) {
+			if doc, err = sjson.DeleteBytes(doc, p); err != nil {
+				return nil, false, err
+			}
+		}
+		return doc, true, nil
+	}), nil
+}
+
+// matchPaths returns the escaped paths of the fields in doc below prefix
+// that match the path segments, which may be wildcard patterns.
+func matchPaths(doc []byte, prefix string, segments []string) []string {
+	obj := gjson.ParseBytes(doc)
+	if prefix != "" {
+		obj = gjson.GetBytes(doc, prefix)
+	}
+	if !obj.IsObject() {
+		return nil
+	}
+	var paths []string
+	obj.ForEach(func(k, v gjson.Result) bool {
+		if ok, _ := path.Match(segments[0], k.String()); !ok {
+			return true
+		}
+		p := pathEscaper.Replace(k.String())
+		if prefix != "" {
+			p = prefix + "." + p
+		}
+		if len(segments) == 1 {
+			paths = append(paths, p)
+		} else {
+			paths = append(paths, matchPaths(doc, p, segments[1:])...)
+		}
+		return true
+	})
+	return paths
+}
+
+// pathEscaper escapes a field name for use in a gjson or sjson path.
+var pathEscaper = strings.NewReplacer(".", `\.`, "*", `\*`, "?", `\?`)
+
+// newMaskTransform replaces the value at a dot path with asterisks, keeping
+// the length of strings.
+func newMaskTransform(arg string) (transformer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("use mask:path")
+	}
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		v := gjson.GetBytes(doc, arg)
+		if !v.Exists() || v.Type == gjson.Null {
+			return doc, true, nil
+		}
+		n := 8
+		if v.Type == gjson.String {
+			n = len([]rune(v.String()))
+		}
+		doc, err := sjson.SetBytes(doc, arg, strings.Repeat("*", n))
+		return doc, true, err
+	}), nil
+}
+
+// newSetTransform sets the value at a dot path, given as path=value. A
+// value that is valid JSON (a number, true, an object, ...) is set as is,
+// anything else as a string.
+func newSetTransform(arg string) (transformer, error) {
+	parts := strings.SplitN(arg, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, fmt.Errorf("use set:path=value")
+	}
+	field := parts[0]
+	value := []byte(parts[1])
+	if !gjson.ValidBytes(value) {
+		value, _ = json.Marshal(parts[1])
+	}
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		doc, err := sjson.SetRawBytes(doc, field, value)
+		return doc, true, err
+	}), nil
+}
+
+// transformHit applies the transforms and templates to a document read
+// from a file, elasticsearch or a queue.
+func transformHit(h interface{}) (interface{}, bool, error) {
+	switch hit := h.(type) {
+	case elastic.SearchHit:
+		src, keep, err := transforms.Transform(hit.Source)
+		if !keep || err != nil {
+			return nil, false, err
+		}
+		hit.Source = src
+		if len(hitTemplates) > 0 {
+			hit.Index, hit.Id, hit.Source, err = applyHitTemplates(hit.Index, hit.Id, hit.Source)
+		}
+		return hit, err == nil, err
+	case rawHit:
+		line, keep, err := transformHit([]byte(hit))
+		if !keep || err != nil {
+			return nil, false, err
+		}
+		return rawHit(line.([]byte)), true, nil
+	case []byte:
+		// An exported line with the document in _source, or a raw document.
+		src := gjson.GetBytes(hit, "_source")
+		if !src.Exists() {
+			doc, keep, err := transforms.Transform(hit)
+			if !keep || err != nil || len(hitTemplates) == 0 {
+				return doc, keep, err
+			}
+			index, id, doc, err := applyHitTemplates("", "", doc)
+			if err != nil || (index == "" && id == "") {
+				return doc, err == nil, err
+			}
+			// Keep the templated _index and _id in an exported line.
+			line, err := json.Marshal(map[string]interface{}{"_index": index, "_id": id, "_source": json.RawMessage(doc)})
+			return line, err == nil, err
+		}
+		doc, keep, err := transforms.Transform([]byte(src.Raw))
+		if !keep || err != nil {
+			return nil, false, err
+		}
+		if len(hitTemplates) > 0 {
+			var index, id string
+			index, id, doc, err = applyHitTemplates(gjson.GetBytes(hit, "_index").String(), gjson.GetBytes(hit, "_id").String(), doc)
+			if err == nil {
+				hit, err = sjson.SetBytes(hit, "_index", index)
+			}
+			if err == nil {
+				hit, err = sjson.SetBytes(hit, "_id", id)
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		line, err := sjson.SetRawBytes(hit, "_source", doc)
+		return line, err == nil, err
+	}
+	return h, true, nil
+}
+
+// transformData applies the transforms to each document sent on the
+// channel and returns the channel the transformed documents are sent on.
+// If there are no transforms or templates, the channel is returned as is.
+func transformData(ctx context.Context, g *errgroup.Group, hits chan interface{}) chan interface{} {
+	if len(transforms) == 0 && len(hitTemplates) == 0 {
+		return hits
+	}
+	out := make(chan interface{})
+	g.Go(func() error {
+		defer close(out)
+		for h := range hits {
+			t, keep, err := transformHit(h)
+			if err != nil {
+				return fmt.Errorf("error transforming document: %s", err.Error())
+			}
+			if !keep {
+				// Count the dropped document as done.
+				if b, ok := h.([]byte); ok {
+					bar.Add64(int64(len(b)))
+				} else {
+					bar.Add64(1)
+				}
+				continue
+			}
+			select {
+			case out <- t:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	return out
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// vaultRef matches a reference to a secret in HashiCorp Vault, as
+// vault:path#field, e.g. vault:secret/data/es#password.
+var vaultRef = regexp.MustCompile(`vault:([A-Za-z0-9_.\-/]+)#([A-Za-z0-9_.\-]+)`)
+
+// kubernetesTokenFile is the service account token of a pod, to log in to
+// Vault with the kubernetes auth method.
+const kubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultClient reads secrets from Vault, configured by the standard
+// VAULT_* environment variables.
+type vaultClient struct {
+	addr    string
+	token   string
+	client  *http.Client
+	secrets map[string]map[string]interface{}
+}
+
+// vault is the client of the references of the command line, created on
+// the first one.
+var vault *vaultClient
+
+// resolveSecrets returns the command line with the references to Vault
+// secrets replaced by the secrets. A secret in a URL is escaped.
+func resolveSecrets(args []string) ([]string, error) {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		s, err := resolveVaultRefs(arg, strings.Contains(arg, "://"))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// resolveVaultRefs replaces the references to Vault secrets of s, escaped
+// for the user info of a URL if escape is set.
+func resolveVaultRefs(s string, escape bool) (string, error) {
+	var err error
+	s = vaultRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		m := vaultRef.FindStringSubmatch(ref)
+		if vault == nil {
+			if vault, err = newVaultClient(); err != nil {
+				return ref
+			}
+		}
+		var secret string
+		if secret, err = vault.secret(m[1], m[2]); err != nil {
+			return ref
+		}
+		if escape {
+			return strings.ReplaceAll(url.QueryEscape(secret), "+", "%20")
+		}
+		return secret
+	})
+	return s, err
+}
+
+// newVaultClient returns a client of the Vault server at VAULT_ADDR,
+// logged in with the first auth method that is configured: a token
+// (VAULT_TOKEN or ~/.vault-token), AppRole (VAULT_ROLE_ID and
+// VAULT_SECRET_ID) or Kubernetes (VAULT_K8S_ROLE in a pod).
+func newVaultClient() (*vaultClient, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set to resolve vault: references")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if os.Getenv("VAULT_SKIP_VERIFY") == "true" || os.Getenv("VAULT_SKIP_VERIFY") == "1" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if file := os.Getenv("VAULT_CACERT"); file != "" {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading VAULT_CACERT: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in VAULT_CACERT %s", file)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	v := &vaultClient{
+		addr:    strings.TrimSuffix(addr, "/"),
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		secrets: map[string]map[string]interface{}{},
+	}
+
+	switch {
+	case os.Getenv("VAULT_TOKEN") != "":
+		v.token = os.Getenv("VAULT_TOKEN")
+	case os.Getenv("VAULT_ROLE_ID") != "":
+		return v, v.login("approle", map[string]string{"role_id": os.Getenv("VAULT_ROLE_ID"), "secret_id": os.Getenv("VAULT_SECRET_ID")})
+	case os.Getenv("VAULT_K8S_ROLE") != "":
+		jwt, err := ioutil.ReadFile(kubernetesTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the service account token: %s", err.Error())
+		}
+		return v, v.login("kubernetes", map[string]string{"role": os.Getenv("VAULT_K8S_ROLE"), "jwt": strings.TrimSpace(string(jwt))})
+	default:
+		home, _ := os.UserHomeDir()
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return nil, fmt.Errorf("no Vault credentials: set VAULT_TOKEN, VAULT_ROLE_ID and VAULT_SECRET_ID, or VAULT_K8S_ROLE, or log in with the vault CLI")
+		}
+		v.token = strings.TrimSpace(string(b))
+	}
+	return v, nil
+}
+
+// login logs in with an auth method mounted at its default path, or at
+// VAULT_AUTH_PATH.
+func (v *vaultClient) login(method string, body map[string]string) error {
+	path := "auth/" + method + "/login"
+	if p := os.Getenv("VAULT_AUTH_PATH"); p != "" {
+		path = "auth/" + strings.Trim(p, "/") + "/login"
+	}
+	var res struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do("POST", path, body, &res); err != nil {
+		return fmt.Errorf("error logging in to Vault with %s: %s", method, err.Error())
+	}
+	v.token = res.Auth.ClientToken
+	return nil
+}
+
+// secret returns a field of the secret at path. The data of a secret of
+// a KV version 2 engine is under data.
+func (v *vaultClient) secret(path, field string) (string, error) {
+	data, ok := v.secrets[path]
+	if !ok {
+		var res struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := v.do("GET", path, nil, &res); err != nil {
+			return "", fmt.Errorf("error reading Vault secret %s: %s", path, err.Error())
+		}
+		data = res.Data
+		if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+			data = inner
+		}
+		v.secrets[path] = data
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("no field %s in Vault secret %s", field, path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+// do sends a request to the Vault API and decodes its response.
+func (v *vaultClient) do(method, path string, body interface{}, res interface{}) error {
+	r := bytes.NewReader(nil)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), method, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), r)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.Unmarshal(b, &e)
+		if len(e.Errors) > 0 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.Join(e.Errors, ", "))
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(b, res)
+}
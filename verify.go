@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	// Compare an export file against an index
+	verifyCmd        = app.Command("verify", "Compare an export file against the index it was imported to (or exported from): document counts and a sample of documents")
+	verifySrcFile    = verifyCmd.Flag("source-file", "Export file to verify (a file with a '.gz' or '.zst' suffix is decompressed first)").Required().ExistingFile()
+	verifyDstURL     = verifyCmd.Flag("dest-url", "Elasticsearch host to compare the file against (http://host:port/)").Required().URL()
+	verifyDstIndex   = verifyCmd.Flag("dest-index", "Elasticsearch index to compare the file against (by default each document is compared with the index it was exported from)").String()
+	verifySampleSize = verifyCmd.Flag("sample-size", "Number of documents picked at random from the file to compare by _id (0 to only compare the counts)").Default("100").Int()
+)
+
+const (
+	// verifyMaxDiffs is the number of differing fields reported per document.
+	verifyMaxDiffs = 5
+	// verifyBatch is the number of documents fetched in each multi get.
+	verifyBatch = 1000
+)
+
+func doVerify() error {
+	client, info, err := newElasticClient((*verifyDstURL).String(), destSide)
+	if err != nil {
+		return err
+	}
+	dstCluster = info
+	logger.Printf("verifying %s against %s\n", *verifySrcFile, (*verifyDstURL).Redacted())
+
+	// Count the documents of each index in the file, and keep a random
+	// sample of those with an _id.
+	counts := map[string]int64{}
+	var sample []elastic.SearchHit
+	var seen int
+	hits := make(chan interface{})
+	g, ctx := errgroup.WithContext(rootCtx)
+	if err = readDataFromFile(ctx, g, *verifySrcFile, hits); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		for h := range hits {
+			line := h.([]byte)
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			hit, err := parseHit(line, "")
+			if err != nil {
+				return err
+			}
+			index := hit.Index
+			if *verifyDstIndex != "" {
+				index = *verifyDstIndex
+			}
+			counts[index]++
+			if hit.Id == "" || index == "" || *verifySampleSize <= 0 {
+				continue
+			}
+			// Reservoir sampling, the source is copied out of the line.
+			hit.Index = index
+			hit.Source = append(json.RawMessage(nil), hit.Source...)
+			seen++
+			if len(sample) < *verifySampleSize {
+				sample = append(sample, hit)
+			} else if i := rand.Intn(seen); i < len(sample) {
+				sample[i] = hit
+			}
+		}
+		return nil
+	})
+	if err = g.Wait(); err != nil {
+		return err
+	}
+
+	failed := 0
+	indices := make([]string, 0, len(counts))
+	for index := range counts {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	for _, index := range indices {
+		if index == "" {
+			logger.Printf("%d documents in the file have no _index, use --dest-index to count them\n", counts[index])
+			failed++
+			continue
+		}
+		n, err := client.Count(index).Do(rootCtx)
+		if err != nil {
+			return fmt.Errorf("error counting documents in index %s: %s", index, err.Error())
+		}
+		if n != counts[index] {
+			logger.Printf("index %s: %d documents in the file, %d in the index\n", index, counts[index], n)
+			failed++
+		} else {
+			logger.Printf("index %s: %d documents\n", index, n)
+		}
+	}
+
+	mismatched, err := verifySample(rootCtx, client, sample)
+	if err != nil {
+		return err
+	}
+	logger.Printf("%d of %d sampled documents match\n", len(sample)-mismatched, len(sample))
+	if failed > 0 || mismatched > 0 {
+		return fmt.Errorf("%d count mismatches and %d document mismatches", failed, mismatched)
+	}
+	logger.Printf("verified\n")
+	return nil
+}
+
+// verifySample gets the sampled documents from the cluster by _id and
+// compares their _source with the file, reporting each mismatch. It
+// returns the number of documents missing or different.
+func verifySample(ctx context.Context, client *elastic.Client, sample []elastic.SearchHit) (int, error) {
+	mismatched := 0
+	for start := 0; start < len(sample); start += verifyBatch {
+		end := start + verifyBatch
+		if end > len(sample) {
+			end = len(sample)
+		}
+		mget := client.Mget()
+		for _, hit := range sample[start:end] {
+			item := elastic.NewMultiGetItem().Index(hit.Index).Id(hit.Id)
+			if t := bulkType(hit, dstCluster); t != "" {
+				item.Type(t)
+			}
+			if hit.Routing != "" {
+				item.Routing(hit.Routing)
+			}
+			mget.Add(item)
+		}
+		res, err := mget.Do(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("error getting the sampled documents: %s", err.Error())
+		}
+		for i, doc := range res.Docs {
+			hit := sample[start+i]
+			if !doc.Found {
+				logger.Printf("document %s/%s is missing\n", hit.Index, hit.Id)
+				mismatched++
+				continue
+			}
+			diffs, err := jsonDiff(hit.Source, doc.Source)
+			if err != nil {
+				return 0, fmt.Errorf("error comparing document %s/%s: %s", hit.Index, hit.Id, err.Error())
+			}
+			if len(diffs) > 0 {
+				if len(diffs) > verifyMaxDiffs {
+					diffs = append(diffs[:verifyMaxDiffs], "...")
+				}
+				logger.Printf("document %s/%s differs: %s\n", hit.Index, hit.Id, strings.Join(diffs, ", "))
+				mismatched++
+			}
+		}
+	}
+	return mismatched, nil
+}
+
+// jsonDiff returns the dot paths at which two JSON documents differ, in
+// order. Objects are compared key by key, other values as a whole.
+func jsonDiff(a, b []byte) ([]string, error) {
+	var va, vb interface{}
+	if err := decodeJSON(a, &va); err != nil {
+		return nil, err
+	}
+	if err := decodeJSON(b, &vb); err != nil {
+		return nil, err
+	}
+	var diffs []string
+	diffValues(va, vb, "", &diffs)
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// decodeJSON decodes a JSON document, keeping numbers as written.
+func decodeJSON(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// diffValues appends the paths at which a and b differ to diffs.
+func diffValues(a, b interface{}, path string, diffs *[]string) {
+	ma, okA := a.(map[string]interface{})
+	mb, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			if path == "" {
+				path = "."
+			}
+			*diffs = append(*diffs, path)
+		}
+		return
+	}
+	prefix := path
+	if prefix != "" {
+		prefix += "."
+	}
+	for k, v := range ma {
+		diffValues(v, mb[k], prefix+k, diffs)
+	}
+	for k, v := range mb {
+		if _, ok := ma[k]; !ok {
+			diffValues(nil, v, prefix+k, diffs)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"github.com/tidwall/gjson"
+)
+
+// clusterInfo is the product and version of a cluster.
+type clusterInfo struct {
+	Distribution string // elasticsearch or opensearch
+	Version      string
+	Major        int
+	Minor        int
+}
+
+// Clusters exported from and imported to, detected on connect.
+var (
+	srcCluster *clusterInfo
+	dstCluster *clusterInfo
+)
+
+// newElasticClient creates a client for the source or destination cluster
+// at url and detects its version. Elasticsearch 8 and later is sent
+// requests in 7.x compatibility mode, which the client is written for.
+func newElasticClient(rawURL, side string) (*elastic.Client, *clusterInfo, error) {
+	next, err := newHTTPTransport(side)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := &compatTransport{next: next}
+	opts, err := clientOptions(rawURL, side)
+	if err != nil {
+		return nil, nil, err
+	}
+	options := append([]elastic.ClientOptionFunc{
+		elastic.SetHttpClient(&http.Client{Transport: transport, Timeout: *requestTimeout}),
+	}, opts...)
+	client, err := elastic.NewClient(options...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating elastic client to url %s: %s", redact(rawURL), err.Error())
+	}
+	info, err := getClusterInfo(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport.compatible = info.Distribution == "elasticsearch" && info.Major >= 8
+	return client, info, nil
+}
+
+// compatTransport asks Elasticsearch to accept requests and send responses
+// as 7.x would, if compatible is set.
+type compatTransport struct {
+	next       http.RoundTripper
+	compatible bool
+}
+
+// RoundTrip sets the compatibility media types on the request.
+func (t *compatTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.compatible {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	switch req.Header.Get("Content-Type") {
+	case "application/x-ndjson":
+		req.Header.Set("Content-Type", "application/vnd.elasticsearch+x-ndjson;compatible-with=7")
+	case "application/json":
+		req.Header.Set("Content-Type", "application/vnd.elasticsearch+json;compatible-with=7")
+	}
+	req.Header.Set("Accept", "application/vnd.elasticsearch+json;compatible-with=7")
+	return t.next.RoundTrip(req)
+}
+
+// getClusterInfo asks the cluster for its product and version, and warns
+// about versions that are not supported.
+func getClusterInfo(client *elastic.Client) (*clusterInfo, error) {
+	res, err := client.PerformRequest(context.Background(), elastic.PerformRequestOptions{Method: "GET", Path: "/"})
+	if err != nil {
+		return nil, fmt.Errorf("error getting cluster version: %s", err.Error())
+	}
+	var root struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+		Tagline string `json:"tagline"`
+	}
+	if err = json.Unmarshal(res.Body, &root); err != nil {
+		return nil, fmt.Errorf("error parsing cluster version: %s", err.Error())
+	}
+	info := &clusterInfo{Distribution: "elasticsearch", Version: root.Version.Number}
+	if root.Version.Distribution != "" {
+		info.Distribution = root.Version.Distribution
+	} else if strings.Contains(root.Tagline, "OpenSearch") {
+		// Some OpenSearch versions only tell in the tagline.
+		info.Distribution = "opensearch"
+	}
+	parts := strings.SplitN(info.Version, ".", 3)
+	info.Major, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		info.Minor, _ = strconv.Atoi(parts[1])
+	}
+	if info.Distribution == "elasticsearch" && info.Major < 6 {
+		logger.Printf("warning: Elasticsearch %s is not supported, use at least 6.0\n", info.Version)
+	}
+	if *debug {
+		logger.Printf("connected to %s %s\n", info.Distribution, info.Version)
+	}
+	return info, nil
+}
+
+// supportsPIT returns whether the cluster supports paging through an index
+// with a point in time and search_after, which is preferred over scrolling.
+func (c *clusterInfo) supportsPIT() bool {
+	return c != nil && c.Distribution == "elasticsearch" && (c.Major > 7 || c.Major == 7 && c.Minor >= 12)
+}
+
+// supportsILM returns whether the cluster has index lifecycle management.
+// OpenSearch has its own index state management instead.
+func (c *clusterInfo) supportsILM() bool {
+	return c != nil && c.Distribution == "elasticsearch"
+}
+
+// typedMappings returns whether the mappings of the cluster are wrapped in
+// a mapping type.
+func (c *clusterInfo) typedMappings() bool {
+	return c != nil && c.Distribution == "elasticsearch" && c.Major < 7
+}
+
+// mappingKeys are the top level keys of typeless mappings.
+var mappingKeys = map[string]bool{
+	"properties": true, "dynamic": true, "dynamic_templates": true, "_source": true,
+	"_meta": true, "_routing": true, "_field_names": true, "date_detection": true,
+	"numeric_detection": true, "enabled": true, "runtime": true, "_all": true,
+}
+
+// adaptMappings returns the mappings of an index in the shape the cluster
+// expects: wrapped in a mapping type before 7.0, typeless from 7.0 on. The
+// type is _doc unless the exported one is preserved with --doc-type, and
+// --doc-type=field adds the field the type is kept in. The mappings of older
+// versions are rewritten (see rewriteMappings), and the parameters the
+// cluster does not support removed (see checkMappings).
+func adaptMappings(m interface{}, c *clusterInfo) (interface{}, error) {
+	mappings, ok := m.(map[string]interface{})
+	if !ok || c == nil {
+		return m, nil
+	}
+	typeName := ""
+	if len(mappings) == 1 {
+		for k, v := range mappings {
+			if inner, isMap := v.(map[string]interface{}); isMap && !mappingKeys[k] {
+				typeName, mappings = k, inner
+			}
+		}
+	}
+	rewriteMappings(mappings)
+	if err := checkMappings(mappings, c); err != nil {
+		return nil, err
+	}
+	mode := c.docTypeMode()
+	if mode == "field" {
+		props, _ := mappings["properties"].(map[string]interface{})
+		if props == nil {
+			props = map[string]interface{}{}
+			mappings["properties"] = props
+		}
+		props[*docTypeField] = map[string]interface{}{"type": "keyword"}
+	}
+	if !c.typedMappings() || len(mappings) == 0 {
+		return mappings, nil
+	}
+	if typeName == "" || mode != "preserve" {
+		typeName = "_doc"
+	}
+	return map[string]interface{}{typeName: mappings}, nil
+}
+
+// readDataWithPIT pages through the index with a point in time and
+// search_after, and sends each hit to the channel. The search preference,
+// if set, chooses the shard copies the point in time is opened on. The sort
+// values are removed from the hits, and those of the last hit of a page are
+// the search_after of the next one (see splitSort).
+func readDataWithPIT(ctx context.Context, srcIndex string, q elastic.Query, preference string, client *elastic.Client, hits chan interface{}) error {
+	params := url.Values{"keep_alive": []string{"5m"}}
+	if preference != "" {
+		params.Set("preference", preference)
+	}
+	res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/" + url.PathEscape(srcIndex) + "/_pit",
+		Params: params,
+	})
+	if err != nil {
+		return fmt.Errorf("error opening point in time on index %s: %s", srcIndex, err.Error())
+	}
+	var pit struct {
+		ID string `json:"id"`
+	}
+	if err = json.Unmarshal(res.Body, &pit); err != nil {
+		return err
+	}
+	defer func() {
+		client.PerformRequest(context.Background(), elastic.PerformRequestOptions{
+			Method: "DELETE",
+			Path:   "/_pit",
+			Body:   map[string]interface{}{"id": pit.ID},
+		})
+	}()
+
+	body := map[string]interface{}{
+		"size": pageSize(),
+		"sort": searchSort("_shard_doc"),
+	}
+	if q != nil {
+		src, err := q.Source()
+		if err != nil {
+			return err
+		}
+		body["query"] = src
+	}
+	for {
+		body["pit"] = map[string]interface{}{"id": pit.ID, "keep_alive": "5m"}
+		res, err := client.PerformRequest(ctx, elastic.PerformRequestOptions{
+			Method: "POST",
+			Path:   "/_search",
+			Params: url.Values{"filter_path": []string{"pit_id," + hitFilterPath + ",hits.hits.sort"}},
+			Body:   body,
+		})
+		if err != nil {
+			return err
+		}
+		pit.ID = gjson.GetBytes(res.Body, "pit_id").String()
+		page, after := splitSort(res.Body)
+		n, _, err := sendRawHits(ctx, page, hits)
+		if err != nil || n == 0 {
+			return err // all results retrieved, or interrupted
+		}
+		body["search_after"] = after
+	}
+}
+
+// splitSort returns a search response without the sort values of its hits,
+// so that they are exported as when scrolling, and the sort values of the
+// last hit to search after.
+func splitSort(body []byte) ([]byte, json.RawMessage) {
+	out := make([]byte, 0, len(body))
+	var after json.RawMessage
+	start := 0
+	gjson.GetBytes(body, "hits.hits").ForEach(func(_, hit gjson.Result) bool {
+		// The end of the previous value in the hit, from which to cut, and
+		// the start of the sort key if it is the first one, up to the next
+		// key.
+		end, first := 0, -1
+		cut := func(from, to int) {
+			out = append(out, body[start:hit.Index+from]...)
+			start = hit.Index + to
+		}
+		gjson.Parse(hit.Raw).ForEach(func(k, v gjson.Result) bool {
+			if first >= 0 {
+				cut(first, k.Index)
+				first = -1
+			}
+			if k.Str == "sort" {
+				after = json.RawMessage(v.Raw)
+				if end > 0 {
+					cut(end, v.Index+len(v.Raw))
+				} else {
+					first = k.Index
+				}
+			}
+			end = v.Index + len(v.Raw)
+			return true
+		})
+		if first >= 0 {
+			cut(first, end)
+		}
+		return true
+	})
+	return append(out, body[start:]...), after
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestSplitSort(t *testing.T) {
+	for _, tt := range []struct {
+		name, body, want, after string
+	}{
+		{
+			name:  "sort last",
+			body:  `{"pit_id":"p","hits":{"hits":[{"_id":"1","_source":{"n":1},"sort":[1,"a"]},{"_id":"2","_source":{"n":2},"sort":[2,"b"]}]}}`,
+			want:  `{"pit_id":"p","hits":{"hits":[{"_id":"1","_source":{"n":1}},{"_id":"2","_source":{"n":2}}]}}`,
+			after: `[2,"b"]`,
+		},
+		{
+			name:  "sort first",
+			body:  `{"hits":{"hits":[{"sort":[1], "_id":"1","_source":{"sort":0}},{ "sort" : [2] ,"_id":"2"}]}}`,
+			want:  `{"hits":{"hits":[{"_id":"1","_source":{"sort":0}},{ "_id":"2"}]}}`,
+			after: `[2]`,
+		},
+		{
+			name:  "sort in the middle",
+			body:  `{"hits":{"hits":[{"_id":"1", "sort" : [1],"_source":{"n":1}}]},"took":1}`,
+			want:  `{"hits":{"hits":[{"_id":"1","_source":{"n":1}}]},"took":1}`,
+			after: `[1]`,
+		},
+		{
+			name:  "sort only",
+			body:  `{"hits":{"hits":[{"sort":[1]}]}}`,
+			want:  `{"hits":{"hits":[{}]}}`,
+			after: `[1]`,
+		},
+		{
+			name: "no hits",
+			body: `{"pit_id":"p","hits":{"total":{"value":0},"hits":[]}}`,
+			want: `{"pit_id":"p","hits":{"total":{"value":0},"hits":[]}}`,
+		},
+	} {
+		got, after := splitSort([]byte(tt.body))
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		if string(after) != tt.after {
+			t.Errorf("%s: got search_after %s, want %s", tt.name, after, tt.after)
+		}
+	}
+}
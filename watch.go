@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	importWatch         = importCmd.Flag("watch", "Directory to watch for export files to import, each moved to its done or failed subdirectory once imported (instead of --source-file)").ExistingDir()
+	importWatchInterval = importCmd.Flag("watch-interval", "How often to look for new files with --watch").Default("10s").Duration()
+)
+
+// Subdirectories of the watched directory the imported files are moved to.
+const (
+	watchDoneDir   = "done"
+	watchFailedDir = "failed"
+)
+
+// watchDirectory imports each export file that appears in dir with an
+// import of its own, run as a child process with the same flags, until
+// interrupted. A file is imported once its mappings file exists and its
+// size has not changed since the previous look, so files still being
+// copied are left alone.
+func watchDirectory(dir string) error {
+	for _, sub := range []string{watchDoneDir, watchFailedDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := signal.NotifyContext(rootCtx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	logger.Printf("watching %s for export files to import every %s\n", dir, *importWatchInterval)
+
+	sizes := map[string]int64{}
+	for {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("error listing %s: %s", dir, err.Error())
+		}
+		seen := map[string]int64{}
+		for _, f := range files {
+			file := filepath.Join(dir, f.Name())
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") || isCompanionFile(file) {
+				continue
+			}
+			seen[file] = f.Size()
+			if size, ok := sizes[file]; !ok || size != f.Size() {
+				continue
+			}
+			if _, err := os.Stat(importMappingsFile(file)); err != nil && *importMappings == "file" && !*importNoMapping {
+				continue
+			}
+			if err = importWatchedFile(ctx, file); ctx.Err() != nil {
+				// Interrupted: leave the file to import again.
+				return nil
+			}
+			sub := watchDoneDir
+			if err != nil {
+				logger.Printf("import of %s failed: %s\n", file, err.Error())
+				sub = watchFailedDir
+			} else {
+				logger.Printf("imported %s\n", file)
+			}
+			if err = moveExportFile(file, filepath.Join(dir, sub)); err != nil {
+				return err
+			}
+			delete(seen, file)
+		}
+		sizes = seen
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*importWatchInterval):
+		}
+	}
+}
+
+// importWatchedFile runs this binary to import a file, with the flags of
+// the watch but --source-file instead of --watch. Its output goes to the
+// output of the watch.
+func importWatchedFile(ctx context.Context, file string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logger.Printf("importing %s\n", file)
+	cmd := exec.CommandContext(ctx, exe, watchedFileArgs(os.Args[1:], file)...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = time.Minute
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// watchedFileArgs returns the command line of the import of a file from
+// the command line of the watch.
+func watchedFileArgs(args []string, file string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		name := strings.SplitN(args[i], "=", 2)[0]
+		if name == "--watch" || name == "--watch-interval" {
+			if !strings.Contains(args[i], "=") {
+				i++ // The value is the next argument.
+			}
+			continue
+		}
+		out = append(out, args[i])
+	}
+	return append(out, "--source-file="+file)
+}
+
+// isCompanionFile returns whether a file is one of the files saved with an
+// export, such as its mappings, rather than the documents.
+func isCompanionFile(file string) bool {
+	if strings.HasSuffix(file, "-mapping.json") {
+		return true
+	}
+	for _, kind := range companionKinds {
+		if strings.HasSuffix(file, "-"+kind+".json") {
+			return true
+		}
+	}
+	return false
+}
+
+// moveExportFile moves an export file and the files saved with it to dir.
+func moveExportFile(file, dir string) error {
+	files := []string{file, mappingsFileName(file)}
+	for _, kind := range companionKinds {
+		files = append(files, companionFile(file, kind))
+	}
+	for _, f := range files {
+		err := os.Rename(f, filepath.Join(dir, filepath.Base(f)))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error moving %s to %s: %s", f, dir, err.Error())
+		}
+	}
+	return nil
+}
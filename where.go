@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+var (
+	whereExprs = app.Flag("where", "Only keep documents for which an expression is true, e.g. 'status == \"error\" && bytes > 1000' (repeatable, all must be true)").Strings()
+)
+
+func init() {
+	registerTransform("where", newWhereTransform)
+}
+
+// newWhereTransform drops documents for which an expression (see
+// https://expr-lang.org) is false. The fields of the document are the
+// variables of the expression, with nested fields as user.name (or
+// user?.name if user may be missing); missing fields are nil.
+func newWhereTransform(arg string) (transformer, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("use where:expression")
+	}
+	program, err := expr.Compile(arg, expr.AsBool(), expr.AllowUndefinedVariables())
+	if err != nil {
+		return nil, err
+	}
+	return transformFunc(func(doc []byte) ([]byte, bool, error) {
+		var env map[string]interface{}
+		if err := json.Unmarshal(doc, &env); err != nil {
+			return nil, false, err
+		}
+		keep, err := vm.Run(program, env)
+		if err != nil {
+			return nil, false, fmt.Errorf("error evaluating %s: %s", arg, err.Error())
+		}
+		return doc, keep.(bool), nil
+	}), nil
+}